@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// alertListing summarises an alert, along with its owner when it is managed by this instance
+type alertListing struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Scope    string `json:"scope"`
+	Managed  bool   `json:"managed"`
+	Template string `json:"template,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+	Group    string `json:"group,omitempty"`
+}
+
+func (c *cli) newAlertsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alerts",
+		Short: "Inspect and delete scanning alerts",
+	}
+	cmd.AddCommand(c.newAlertsListCommand(), c.newAlertsShowCommand(), c.newAlertsDeleteCommand())
+	return cmd
+}
+
+func (c *cli) newAlertsListCommand() *cobra.Command {
+	var managedOnly bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the scanning alerts of the backend",
+		Args:  cobra.NoArgs,
+		Annotations: map[string]string{
+			annotationDataOutput: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, api, err := c.singleEnvironment()
+			if err != nil {
+				return err
+			}
+			existing, err := api.Alerts.List(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("could not retrieve alerts: %w", err)
+			}
+
+			listings := []alertListing{}
+			for _, alert := range existing.Alerts {
+				o, managed := managedOwner(alert, env.Config.InstanceID)
+				if managedOnly && !managed {
+					continue
+				}
+				listings = append(listings, alertListing{
+					ID:       alert.AlertID,
+					Name:     alert.Name,
+					Enabled:  alert.Enabled,
+					Scope:    alert.Scope,
+					Managed:  managed,
+					Template: o.Template,
+					Cluster:  o.Cluster,
+					Group:    o.Group,
+				})
+			}
+			return printAlerts(cmd.OutOrStdout(), listings, env.Config.Output)
+		},
+	}
+	cmd.Flags().BoolVar(&managedOnly, "managed", false, "Only list the alerts managed by this instance")
+	return cmd
+}
+
+func printAlerts(w io.Writer, listings []alertListing, format string) error {
+	if format == configuration.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listings)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "ID\tENABLED\tMANAGED\tNAME\tSCOPE"); err != nil {
+		return err
+	}
+	for _, listing := range listings {
+		managed := "-"
+		if listing.Managed {
+			managed = fmt.Sprintf("%s/%s", listing.Template, listing.Cluster)
+			if listing.Group != "" {
+				managed = fmt.Sprintf("%s/%s", managed, listing.Group)
+			}
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%s\n", listing.ID, listing.Enabled, managed, listing.Name, listing.Scope); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// findAlertByRef returns the alert with the given ID or, failing that, the only alert with the given name
+func findAlertByRef(existing *alerts.AlertQuery, ref string) (*alerts.Alert, error) {
+	for i := range existing.Alerts {
+		if existing.Alerts[i].AlertID == ref {
+			return &existing.Alerts[i], nil
+		}
+	}
+	alert, err := findAlertByName(existing, ref)
+	if err != nil {
+		return nil, err
+	}
+	if alert == nil {
+		return nil, fmt.Errorf("no alert with ID or name '%s'", ref)
+	}
+	return alert, nil
+}
+
+// findAlertByName returns the only alert with the given name, nil if there is none, or an error listing the IDs of
+// the alerts sharing the name
+func findAlertByName(existing *alerts.AlertQuery, name string) (*alerts.Alert, error) {
+	var byName []*alerts.Alert
+	for i := range existing.Alerts {
+		if existing.Alerts[i].Name == name {
+			byName = append(byName, &existing.Alerts[i])
+		}
+	}
+	switch len(byName) {
+	case 0:
+		return nil, nil
+	case 1:
+		return byName[0], nil
+	}
+	var ids []string
+	for _, alert := range byName {
+		ids = append(ids, alert.AlertID)
+	}
+	return nil, fmt.Errorf("%d alerts are named '%s', use one of the IDs %s", len(byName), name, strings.Join(ids, ", "))
+}
+
+func (c *cli) newAlertsShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <name|id>",
+		Short: "Show a scanning alert",
+		Args:  cobra.ExactArgs(1),
+		Annotations: map[string]string{
+			annotationDataOutput: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, api, err := c.singleEnvironment()
+			if err != nil {
+				return err
+			}
+			existing, err := api.Alerts.List(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("could not retrieve alerts: %w", err)
+			}
+			alert, err := findAlertByRef(existing, args[0])
+			if err != nil {
+				return err
+			}
+			return printAlert(cmd.OutOrStdout(), *alert, env.Config)
+		},
+	}
+}
+
+func printAlert(w io.Writer, alert alerts.Alert, config *configuration.Config) error {
+	if config.Output == configuration.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(alert)
+	}
+
+	managedBy := "unmanaged"
+	if o, ok := parseOwnerMarker(alert.Description); ok {
+		managedBy = fmt.Sprintf("instance '%s', template '%s', cluster '%s'", o.InstanceID, o.Template, o.Cluster)
+		if o.Group != "" {
+			managedBy = fmt.Sprintf("%s, group '%s'", managedBy, o.Group)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, field := range [][2]interface{}{
+		{"ID", alert.AlertID},
+		{"Name", alert.Name},
+		{"Enabled", alert.Enabled},
+		{"Type", alert.Type},
+		{"Scope", alert.Scope},
+		{"Description", alert.Description},
+		{"Repositories", alert.Repositories},
+		{"Triggers", alert.Triggers},
+		{"Autoscan", alert.Autoscan},
+		{"Only pass/fail", alert.OnlyPassFail},
+		{"Notification channels", alert.NotificationChannelIds},
+		{"Managed by", managedBy},
+	} {
+		if _, err := fmt.Fprintf(tw, "%s:\t%v\n", field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *cli) newAlertsDeleteCommand() *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "delete <name|id>...",
+		Short: "Delete scanning alerts managed by this instance",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, api, err := c.singleEnvironment()
+			if err != nil {
+				return err
+			}
+			existing, err := api.Alerts.List(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("could not retrieve alerts: %w", err)
+			}
+
+			plan := syncPlan{Changes: []plannedChange{}}
+			for _, ref := range args {
+				alert, err := findAlertByRef(existing, ref)
+				if err != nil {
+					return err
+				}
+				o, managed := managedOwner(*alert, env.Config.InstanceID)
+				if !managed && !force {
+					return fmt.Errorf("alert '%s' (%s) is not managed by instance '%s', use --force to delete it anyway", alert.Name, alert.AlertID, env.Config.InstanceID)
+				}
+				plan.Changes = append(plan.Changes, plannedChange{Action: actionDelete, Cluster: o.Cluster, Group: o.Group, Template: o.Template, AlertID: alert.AlertID, Name: alert.Name})
+			}
+
+			if env.Config.DryRun {
+				return printPlan(cmd.OutOrStdout(), plan, env.Config.Output)
+			}
+			report, err := applyPlan(cmd.Context(), c.logger, env.Config, plan, api)
+			if err != nil {
+				reportShutdown(c.logger, report)
+				return err
+			}
+			logSummary(c.logger, report)
+			return report.err()
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Also delete alerts that are not managed by this instance")
+	configuration.AddFlags(cmd.Flags(), "dry-run", "workers", "operation_timeout")
+	return cmd
+}
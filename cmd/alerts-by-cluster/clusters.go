@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"github.com/spf13/cobra"
+	"io"
+	"text/tabwriter"
+)
+
+// clusterListing is a discovered cluster, or group within a cluster, along with whether the selectors keep it
+type clusterListing struct {
+	Environment string            `json:"environment,omitempty"`
+	Cluster     string            `json:"cluster,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Selected    bool              `json:"selected"`
+	Reason      string            `json:"reason,omitempty"`
+}
+
+func (c *cli) newClustersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clusters",
+		Short: "Inspect the discovered kubernetes clusters",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the discovered clusters and whether the cluster selectors keep them",
+		Args:  cobra.NoArgs,
+		Annotations: map[string]string{
+			annotationDataOutput: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var listings []clusterListing
+			for _, env := range c.config().ResolveEnvironments() {
+				envListings, err := c.listClusters(cmd, env)
+				if err != nil {
+					return err
+				}
+				listings = append(listings, envListings...)
+			}
+			return printClusters(cmd.OutOrStdout(), listings, c.config().Output)
+		},
+	}
+	configuration.AddFlags(list.Flags(), discoveryFlags...)
+
+	cmd.AddCommand(list)
+	return cmd
+}
+
+// listClusters discovers the targets of an environment and evaluates them against its selectors
+func (c *cli) listClusters(cmd *cobra.Command, env configuration.EnvironmentConfig) ([]clusterListing, error) {
+	filter, err := newTargetFilter(env.Config)
+	if err != nil {
+		return nil, err
+	}
+	api, err := newAPI(c.logger, env.Config)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := discoverTargets(cmd.Context(), c.logger, env.Config, api)
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]clusterListing, 0, len(targets))
+	for _, t := range targets {
+		selected, reason := filter.Evaluate(t)
+		listings = append(listings, clusterListing{Environment: env.Name, Cluster: t.Cluster.Name, Group: t.group(), Tags: t.tags(), Source: t.Cluster.Source, Selected: selected, Reason: reason})
+	}
+	return listings, nil
+}
+
+func printClusters(w io.Writer, listings []clusterListing, format string) error {
+	if format == configuration.OutputJSON {
+		if listings == nil {
+			listings = []clusterListing{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listings)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "ENVIRONMENT\tCLUSTER\tGROUP\tTAGS\tSOURCE\tSELECTED\tREASON"); err != nil {
+		return err
+	}
+	for _, listing := range listings {
+		environment := listing.Environment
+		if environment == "" {
+			environment = "-"
+		}
+		cluster, group := listing.Cluster, listing.Group
+		if cluster == "" {
+			cluster = "-"
+		}
+		if group == "" {
+			group = "-"
+		}
+		tags := inventory.Cluster{Tags: listing.Tags}.TagString()
+		if tags == "" {
+			tags = "-"
+		}
+		source := listing.Source
+		if source == "" {
+			source = "-"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", environment, cluster, group, tags, source, listing.Selected, listing.Reason); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// annotationSkipConfig marks commands that run without loading or validating the configuration
+	annotationSkipConfig = "skip-config"
+	// annotationDataOutput marks commands whose stdout carries data, their logs are sent to stderr instead
+	annotationDataOutput = "data-output"
+)
+
+// connectionFlags are understood by every command, they select and configure the backends
+var connectionFlags = []string{"secure_url", "secure_api_token", "environment", "instance_id", "ca_bundle", "insecure_skip_verify", "https_proxy", "no_proxy", "rate_limit", "output"}
+
+// discoveryFlags control which clusters are discovered and selected
+var discoveryFlags = []string{"include", "exclude", "include_namespaces", "exclude_namespaces", "group_by", "filter", "tags", "page_size", "lookback"}
+
+// applyFlags control how changes are applied
+var applyFlags = []string{"workers", "operation_timeout", "parallel_environments"}
+
+// cli holds the state shared by the commands of a single invocation
+type cli struct {
+	logger        *logrus.Logger
+	configManager *configuration.ConfigManager
+}
+
+func (c *cli) config() *configuration.Config {
+	return c.configManager.GetConfig()
+}
+
+// newRootCommand builds the command tree.  Running the binary without a subcommand syncs, as earlier versions did.
+func newRootCommand(logger *logrus.Logger) *cobra.Command {
+	c := &cli{logger: logger, configManager: configuration.NewConfigManager(logger)}
+
+	root := &cobra.Command{
+		Use:               "alerts-by-cluster",
+		Short:             "Manage Sysdig runtime scanning alerts for each kubernetes cluster",
+		SilenceErrors:     true,
+		PersistentPreRunE: c.loadConfig,
+		RunE:              c.runSync,
+	}
+	configuration.AddFlags(root.PersistentFlags(), connectionFlags...)
+	configuration.AddFlags(root.Flags(), syncCommandFlags()...)
+
+	root.AddCommand(
+		c.newSyncCommand(),
+		c.newPlanCommand(),
+		c.newClustersCommand(),
+		c.newAlertsCommand(),
+		c.newPruneCommand(),
+		c.newExportCommand(),
+		c.newImportCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+// loadConfig binds the flags of the command being run, then loads and validates the configuration
+func (c *cli) loadConfig(cmd *cobra.Command, args []string) error {
+	// Usage is only useful for command-line errors, which cobra reports before this runs
+	cmd.SilenceUsage = true
+	if cmd.Annotations[annotationSkipConfig] != "" {
+		return nil
+	}
+	if cmd.Annotations[annotationDataOutput] != "" {
+		c.logger.SetOutput(cmd.ErrOrStderr())
+	}
+
+	if err := c.configManager.BindFlags(cmd.Flags()); err != nil {
+		return err
+	}
+	// The output given on the command line is known before loading the configuration, whose own logs must not end
+	// up in the JSON either
+	if flag := cmd.Flags().Lookup("output"); flag != nil && flag.Value.String() == configuration.OutputJSON {
+		c.logger.SetOutput(cmd.ErrOrStderr())
+	}
+	if err := c.configManager.LoadConfig(); err != nil {
+		return fmt.Errorf("could not load configuration: %w", err)
+	}
+	if err := c.configManager.ValidateConfig(); err != nil {
+		return fmt.Errorf("could not validate configuration: %w", err)
+	}
+	if c.config().Output == configuration.OutputJSON {
+		// Keep stdout clean for JSON output
+		c.logger.SetOutput(cmd.ErrOrStderr())
+	}
+	return nil
+}
+
+// singleEnvironment returns the environment used by commands that work against one backend
+func (c *cli) singleEnvironment() (configuration.EnvironmentConfig, *secure.Client, error) {
+	environments := c.config().ResolveEnvironments()
+	if len(environments) != 1 {
+		return configuration.EnvironmentConfig{}, nil, fmt.Errorf("%d environments are configured, select one with --environment", len(environments))
+	}
+	api, err := newAPI(c.logger, environments[0].Config)
+	if err != nil {
+		return configuration.EnvironmentConfig{}, nil, err
+	}
+	return environments[0], api, nil
+}
+
+func syncCommandFlags() []string {
+	flags := append([]string{"prune", "prune_action", "prune_allow_empty", "adopt"}, discoveryFlags...)
+	flags = append(flags, applyFlags...)
+	return append(flags, "dry-run")
+}
+
+func (c *cli) runSync(cmd *cobra.Command, args []string) error {
+	return run(cmd.Context(), c.logger, c.config(), cmd.OutOrStdout(), buildPlan)
+}
+
+func (c *cli) newSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Create, update and optionally prune the alerts of every discovered cluster",
+		Args:  cobra.NoArgs,
+		RunE:  c.runSync,
+	}
+	configuration.AddFlags(cmd.Flags(), syncCommandFlags()...)
+	return cmd
+}
+
+func (c *cli) newPlanCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Print the changes sync would make, without applying them",
+		Args:  cobra.NoArgs,
+		Annotations: map[string]string{
+			annotationDataOutput: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.config().DryRun = true
+			return run(cmd.Context(), c.logger, c.config(), cmd.OutOrStdout(), buildPlan)
+		},
+	}
+	configuration.AddFlags(cmd.Flags(), append([]string{"prune", "prune_action", "prune_allow_empty", "adopt", "parallel_environments"}, discoveryFlags...)...)
+	return cmd
+}
+
+// prunePlan plans only the removal of stale managed alerts, see planPrune
+func prunePlan(config *configuration.Config, router *channelRouter, existing *alerts.AlertQuery, targets []target) (syncPlan, error) {
+	changes, err := planPrune(config, existing, targets)
+	if err != nil {
+		return syncPlan{}, err
+	}
+	plan := syncPlan{Changes: changes}
+	if plan.Changes == nil {
+		plan.Changes = []plannedChange{}
+	}
+	return plan, nil
+}
+
+func (c *cli) newPruneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Disable or delete managed alerts of clusters that no longer exist, without creating or updating any",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.config().Prune.Enabled = true
+			return run(cmd.Context(), c.logger, c.config(), cmd.OutOrStdout(), prunePlan)
+		},
+	}
+	// Prune discovers the same targets as sync, otherwise alerts of groups it does not know of would look stale
+	flags := append([]string{"prune_action", "prune_allow_empty", "dry-run"}, discoveryFlags...)
+	configuration.AddFlags(cmd.Flags(), append(flags, applyFlags...)...)
+	return cmd
+}
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version",
+		Args:  cobra.NoArgs,
+		Annotations: map[string]string{
+			annotationSkipConfig: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "alerts-by-cluster %s\n", VERSION)
+			return err
+		},
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+)
+
+// environmentResult is the outcome of syncing a single environment.  Err is set when the environment could not be
+// planned at all, or when applying its plan was interrupted.
+type environmentResult struct {
+	Name    string
+	URL     string
+	Plan    syncPlan
+	Report  syncReport
+	Applied bool
+	Err     error
+}
+
+// label names the environment in logs and plans, or is empty for the unnamed environment of a single-backend config
+func (r environmentResult) label() string {
+	if r.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("environment '%s'", r.Name)
+}
+
+// err returns the failures of the environment, or nil when every cluster was synced
+func (r environmentResult) err() error {
+	err := r.Err
+	if err == nil {
+		err = r.Report.err()
+	}
+	if err == nil || r.Name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", r.label(), err)
+}
+
+// syncEnvironments syncs every selected environment, concurrently when config.Parallel is set.  Results are
+// returned in configuration order.
+func syncEnvironments(ctx context.Context, logger *logrus.Logger, config *configuration.Config, plan planner) []environmentResult {
+	environments := config.ResolveEnvironments()
+	results := make([]environmentResult, len(environments))
+
+	syncOne := func(i int) {
+		env := environments[i]
+		if env.Name != "" {
+			logger.Infof("Syncing environment '%s' (%s)", env.Name, env.Config.SecureURL)
+		}
+		results[i] = syncEnvironment(ctx, logger, env.Name, env.Config, plan)
+	}
+
+	if !config.Parallel {
+		for i := range environments {
+			if ctx.Err() != nil {
+				results[i] = environmentResult{Name: environments[i].Name, URL: environments[i].Config.SecureURL, Err: ctx.Err()}
+				continue
+			}
+			syncOne(i)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	for i := range environments {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			syncOne(i)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// environmentPlan is the JSON form of the plan for one environment
+type environmentPlan struct {
+	Environment string `json:"environment"`
+	URL         string `json:"url"`
+	Error       string `json:"error,omitempty"`
+	syncPlan
+}
+
+// printPlans writes the plan of every environment.  A config without environments prints a single plan, exactly as
+// printPlan does.
+func printPlans(w io.Writer, results []environmentResult, format string) error {
+	if len(results) == 1 && results[0].Name == "" {
+		if results[0].Err != nil {
+			// Nothing to print, the failure is reported by environmentsErr
+			return nil
+		}
+		return printPlan(w, results[0].Plan, format)
+	}
+
+	if format == configuration.OutputJSON {
+		plans := make([]environmentPlan, 0, len(results))
+		for _, result := range results {
+			plan := environmentPlan{Environment: result.Name, URL: result.URL, syncPlan: result.Plan}
+			if result.Err != nil {
+				plan.Error = result.Err.Error()
+			}
+			if plan.Changes == nil {
+				plan.Changes = []plannedChange{}
+			}
+			plans = append(plans, plan)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plans)
+	}
+
+	for i, result := range results {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "Environment '%s' (%s):\n", result.Name, result.URL); err != nil {
+			return err
+		}
+		if result.Err != nil {
+			if _, err := fmt.Fprintf(w, "  ! error: %v\n", result.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := printPlan(w, result.Plan, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logEnvironmentSummary logs the outcome of every environment, grouped by environment
+func logEnvironmentSummary(logger *logrus.Logger, results []environmentResult) {
+	for _, result := range results {
+		if label := result.label(); label != "" {
+			logger.Infof("Results for %s (%s):", label, result.URL)
+		}
+		switch {
+		case result.Applied && result.Err != nil:
+			reportShutdown(logger, result.Report)
+		case result.Err != nil:
+			if result.Name != "" {
+				logger.Errorf("  FAILED: %v", result.Err)
+			}
+		default:
+			logSummary(logger, result.Report)
+		}
+	}
+}
+
+// environmentsErr combines the failures of every environment into a single error, or returns nil
+func environmentsErr(results []environmentResult) error {
+	var messages []string
+	for _, result := range results {
+		if err := result.err(); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -1,150 +1,248 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/loggerpkg"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/sysdighttp"
 	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
 	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/notification"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
-	"net/http"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func retrieveClusters(logger *logrus.Logger, config *configuration.Config, client sysdighttp.SysdigClient) (*metadata.ResultMetadata, error) {
-	var err error
-	// Get list of kubernetes clusters in environment
-	configClusters := sysdighttp.DefaultSysdigRequestConfig(fmt.Sprintf("%s/api/data/entity/metadata", viper.GetString("SECURE_URL")), config.SecureAPIToken)
-	configClusters.Method = "POST"
-	configClusters.Headers = map[string]string{
-		"Content-Type": "application/json",
+// discoveryQuery returns a metadata query for the given labels, restricted by the discovery filter and by the lookback
+// window ending at now, so that every page queries the same range
+func discoveryQuery(config *configuration.Config, labels []string, now time.Time) (*secure.MetadataQuery, error) {
+	query := secure.NewMetadataQuery(labels...).Filter(config.Discovery.Filter).PageSize(config.Discovery.PageSize)
+	lookback, err := configuration.ParseLookback(config.Discovery.Lookback)
+	if err != nil {
+		return nil, err
 	}
-	configClusters.JSON = metadata.PayloadMetadata{
-		Paging: metadata.PagingPayload{
-			From: 0,
-			To:   9999,
-		},
-		Metrics: []string{"kubernetes.cluster.name"},
+	if lookback > 0 {
+		query.TimeRange(now.Add(-lookback), now)
 	}
+	return query, nil
+}
 
-	var objMetadataResponse *http.Response
-	if objMetadataResponse, err = client.SysdigRequest(logger, configClusters); err != nil {
-		logger.Fatalf("Error creating sysdig request: %s", err)
+// retrieveClusters pages through the metadata endpoint until the reported total is reached, returning each
+// combination of the discovery group_by labels once, e.g. each cluster or each namespace of each cluster
+func retrieveClusters(ctx context.Context, logger *logrus.Logger, config *configuration.Config, api *secure.Client) (*metadata.ResultMetadata, error) {
+	now := time.Now()
+	query, err := discoveryQuery(config, groupBy(config), now)
+	if err != nil {
+		return nil, err
+	}
+	if lookback, _ := configuration.ParseLookback(config.Discovery.Lookback); lookback > 0 {
+		logger.Infof("Discovering clusters seen between %s and %s (lookback %s)", now.Add(-lookback).Format(time.RFC3339), now.Format(time.RFC3339), config.Discovery.Lookback)
+	} else {
+		logger.Infof("Discovering clusters using the backend's default time window")
+	}
+	if config.Discovery.Filter != "" {
+		logger.Infof("Discovering only entities matching '%s'", config.Discovery.Filter)
 	}
-	defer objMetadataResponse.Body.Close()
 
-	jsonMetadataResponse := &metadata.ResultMetadata{}
-	if err = client.ResponseBodyToJson(objMetadataResponse, jsonMetadataResponse); err != nil {
-		logger.Fatalf("Error unmarshalling sysdig response: %s", err)
+	clusters, err := api.Metadata.Rows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying cluster metadata: %w", err)
 	}
-	return jsonMetadataResponse, nil
+	return clusters, nil
 }
 
-func getAlerts(logger *logrus.Logger, config *configuration.Config, client sysdighttp.SysdigClient) (*alerts.AlertQuery, error) {
-	var err error
-	// Get list of kubernetes clusters in environment
-	configAlerts := sysdighttp.DefaultSysdigRequestConfig(fmt.Sprintf("%s/api/scanning/v1/alerts", config.SecureURL), config.SecureAPIToken)
-	configAlerts.Method = "GET"
-	var objAlertsResponse *http.Response
-	if objAlertsResponse, err = client.SysdigRequest(logger, configAlerts); err != nil {
+// retrieveClusterTags collects the discovery.tags agent tags of every cluster
+func retrieveClusterTags(ctx context.Context, logger *logrus.Logger, config *configuration.Config, api *secure.Client) ([]inventory.Cluster, error) {
+	labels := append([]string{configuration.LabelClusterName}, inventory.TagLabels(config.Discovery.Tags)...)
+	query, err := discoveryQuery(config, labels, time.Now())
+	if err != nil {
 		return nil, err
 	}
-	defer objAlertsResponse.Body.Close()
+	logger.Infof("Collecting agent tags %v of every cluster", config.Discovery.Tags)
 
-	jsonAlerts := &alerts.AlertQuery{}
-	if err = client.ResponseBodyToJson(objAlertsResponse, jsonAlerts); err != nil {
-		return nil, err
+	rows, err := api.Metadata.Rows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying cluster tags: %w", err)
+	}
+	clusters, conflicts := inventory.FromMetadata(rows.Data, config.Discovery.Tags)
+	for _, conflict := range conflicts {
+		logger.Warnf("Agents of cluster '%s' report several values for tag '%s' (%s), using '%s'", conflict.Cluster, conflict.Key, strings.Join(conflict.Values, ", "), conflict.Values[0])
 	}
-	return jsonAlerts, nil
+	return clusters, nil
 }
 
-func alertExists(alerts *alerts.AlertQuery, clusterName string) bool {
-	for _, alert := range alerts.Alerts {
-		if alert.Scope == fmt.Sprintf("kubernetes.cluster.name = \"%s\"", clusterName) {
-			return true
+// findAlert returns the alert carrying the ownership marker for an owner
+func findAlert(alerts *alerts.AlertQuery, o owner) *alerts.Alert {
+	for i := range alerts.Alerts {
+		if markerOwner, ok := parseOwnerMarker(alerts.Alerts[i].Description); ok && markerOwner == o {
+			return &alerts.Alerts[i]
 		}
 	}
-	return false
+	return nil
 }
 
-func createAlertForCluster(logger *logrus.Logger, config *configuration.Config, clusterName string, client sysdighttp.SysdigClient) error {
-	var err error
-	configCreateAlert := sysdighttp.DefaultSysdigRequestConfig(fmt.Sprintf("%s/api/scanning/v1/alerts", config.SecureURL), config.SecureAPIToken)
-	configCreateAlert.Method = "POST"
-	configCreateAlert.Headers = map[string]string{
-		"Content-Type": "application/json",
-	}
-	configCreateAlert.JSON = alerts.PayloadAlert{
-		Enabled:      true,
-		Type:         "runtime",
-		Name:         fmt.Sprintf("Cluster: %s", clusterName),
-		Description:  "",
-		Scope:        fmt.Sprintf("kubernetes.cluster.name = \"%s\"", clusterName),
-		Repositories: []string{},
-		Triggers: alerts.PayloadTriggers{
-			Unscanned:      true,
-			AnalysisUpdate: false,
-			VulnUpdate:     true,
-			PolicyEval:     true,
-		},
-		Autoscan:               false,
-		OnlyPassFail:           false,
-		NotificationChannelIds: []string{},
-	}
-
-	var objAlertResponse *http.Response
-	if objAlertResponse, err = client.SysdigRequest(logger, configCreateAlert); err != nil {
-		return err
+// findUnmanagedAlert returns an alert without any ownership marker whose scope is equivalent to the given scope,
+// however it is spelled
+func findUnmanagedAlert(alerts *alerts.AlertQuery, alertScope string) *alerts.Alert {
+	for i := range alerts.Alerts {
+		if _, ok := parseOwnerMarker(alerts.Alerts[i].Description); !ok && scope.EqualStrings(alerts.Alerts[i].Scope, alertScope) {
+			return &alerts.Alerts[i]
+		}
 	}
-	defer objAlertResponse.Body.Close()
 	return nil
 }
 
+// newSysdigClient creates the client shared by every request, with its transport tuned from config
+func newSysdigClient(logger *logrus.Logger, config *configuration.Config) (sysdighttp.SysdigClient, error) {
+	tlsConfig, err := sysdighttp.NewTLSConfig(sysdighttp.TLSOptions{
+		CABundle:           config.TLS.CABundle,
+		ClientCert:         config.TLS.ClientCert,
+		ClientKey:          config.TLS.ClientKey,
+		MinVersion:         config.TLS.MinVersion,
+		InsecureSkipVerify: config.TLS.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := sysdighttp.ProxyFunc(config.HTTP.HTTPSProxy, config.HTTP.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := config.HTTP.UserAgent
+	if userAgent == "" {
+		userAgent = fmt.Sprintf("alerts-by-cluster/%s", VERSION)
+	}
+	if config.TLS.InsecureSkipVerify {
+		logger.Warn("!!! TLS certificate verification is DISABLED (insecure_skip_verify).  Connections to the backend can be intercepted, do not use this in production !!!")
+	}
+
+	return sysdighttp.NewSysdigClient(
+		sysdighttp.WithMaxIdleConns(config.HTTP.MaxIdleConns),
+		sysdighttp.WithMaxIdleConnsPerHost(config.HTTP.MaxIdleConnsPerHost),
+		sysdighttp.WithIdleConnTimeout(config.HTTP.IdleConnTimeout),
+		sysdighttp.WithHTTP2(config.HTTP.HTTP2),
+		sysdighttp.WithTLSConfig(tlsConfig),
+		sysdighttp.WithProxy(proxy),
+		sysdighttp.WithHeaders(config.HTTP.Headers),
+		sysdighttp.WithUserAgent(userAgent),
+		sysdighttp.WithRateLimit(config.HTTP.RateLimit, config.HTTP.RateBurst),
+	), nil
+}
+
+// newAPI creates the typed Sysdig Secure client for the backend of an environment
+func newAPI(logger *logrus.Logger, config *configuration.Config) (*secure.Client, error) {
+	client, err := newSysdigClient(logger, config)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure HTTP client: %w", err)
+	}
+	return secure.NewClient(logger, client, config.SecureURL, config.SecureAPIToken), nil
+}
+
 var VERSION = "1.0.1"
 
-func main() {
+// planner computes the changes for an environment from its alerts and discovered targets, see buildPlan
+type planner func(config *configuration.Config, router *channelRouter, existing *alerts.AlertQuery, targets []target) (syncPlan, error)
+
+// syncEnvironment plans the changes for a single environment and, unless this is a dry run, applies them.  Failures
+// that prevent planning the environment are returned in the result rather than stopping the other environments.
+func syncEnvironment(ctx context.Context, logger *logrus.Logger, name string, config *configuration.Config, plan planner) environmentResult {
 	var err error
-	var arrClusters *metadata.ResultMetadata
+	var targets []target
 	var arrAlerts *alerts.AlertQuery
+	result := environmentResult{Name: name, URL: config.SecureURL}
 
-	logger := loggerpkg.GetLogger()
-	logger.Infof("Alerts-by-cluster.  Version: %s", VERSION)
-	logger.Info("Creates runtime scanning alerts for each kubernetes cluster\n")
-
-	client := sysdighttp.NewSysdigClient()
-	configManager := configuration.NewConfigManager(logger)
-	if err = configManager.LoadConfig(); err != nil {
-		logger.Fatalf("Could not load configuration, exiting.. Error: '%v'", err)
+	api, err := newAPI(logger, config)
+	if err != nil {
+		result.Err = err
+		return result
 	}
 
-	if err = configManager.ValidateConfig(); err != nil {
-		logger.Fatalf("Could not validate configuration, exiting.. Error: '%v'", err)
+	if targets, err = discoverTargets(ctx, logger, config, api); err != nil {
+		result.Err = err
+		return result
 	}
 
-	if arrClusters, err = retrieveClusters(logger, configManager.GetConfig(), client); err != nil {
-		logger.Fatalf("Could not retrieve clusters. error: '%v'", err)
+	if arrAlerts, err = api.Alerts.List(ctx); err != nil {
+		result.Err = fmt.Errorf("could not retrieve alerts: %w", err)
+		return result
 	}
 
-	if arrAlerts, err = getAlerts(logger, configManager.GetConfig(), client); err != nil {
-		logger.Fatalf("Could not retrieve alerts.  error '%v'", err)
+	var router *channelRouter
+	if len(config.Routing) > 0 {
+		var arrChannels *notification.ChannelQuery
+		if arrChannels, err = api.NotificationChannels.List(ctx); err != nil {
+			result.Err = fmt.Errorf("could not retrieve notification channels: %w", err)
+			return result
+		}
+		if router, err = newChannelRouter(config, arrChannels); err != nil {
+			result.Err = fmt.Errorf("could not resolve notification channel routing: %w", err)
+			return result
+		}
 	}
-	_ = arrAlerts
 
-	for _, cluster := range arrClusters.Data {
-		if alertExists(arrAlerts, cluster.KubernetesClusterName) == true {
-			logger.Debugf("Alert for cluster '%s' already exists, skipping..", cluster.KubernetesClusterName)
+	if result.Plan, err = plan(config, router, arrAlerts, targets); err != nil {
+		result.Err = fmt.Errorf("could not build plan: %w", err)
+		return result
+	}
+	for _, skipped := range result.Plan.Skipped {
+		if skipped.Template == "" {
+			logger.Debugf("Skipping %s: %s", skipped.describe(), skipped.Reason)
 		} else {
-			logger.Debugf("Alert for cluster '%s' does not exist, creating alert '%s' with scope '%s'",
-				cluster.KubernetesClusterName,
-				fmt.Sprintf("Cluster: %s", cluster.KubernetesClusterName),
-				fmt.Sprintf("kubernetes.cluster.name = \"%s\"", cluster.KubernetesClusterName))
-
-			if err = createAlertForCluster(logger, configManager.GetConfig(), cluster.KubernetesClusterName, client); err != nil {
-				logger.Fatalf("Could not create alert for cluster '%s'. Error: '%v'", cluster.KubernetesClusterName, err)
-			}
+			logger.Warnf("Skipping %s: %s", skipped.describe(), skipped.Reason)
 		}
 	}
 
+	if config.DryRun {
+		result.Report = syncReport{Failed: result.Plan.Failed}
+		return result
+	}
+	result.Applied = true
+	result.Report, result.Err = applyPlan(ctx, logger, config, result.Plan, api)
+	return result
+}
+
+// run syncs every configured environment with the changes computed by plan, returning an error instead of exiting
+// so that every failure is reported in one place.  Dry run plans are written to out.
+func run(ctx context.Context, logger *logrus.Logger, config *configuration.Config, out io.Writer, plan planner) error {
+	logger.Infof("Alerts-by-cluster.  Version: %s", VERSION)
+
+	results := syncEnvironments(ctx, logger, config, plan)
+
+	if config.DryRun {
+		if err := printPlans(out, results, config.Output); err != nil {
+			return fmt.Errorf("could not print plan: %w", err)
+		}
+		logger.Infof("Dry run, no changes applied.")
+	} else {
+		logEnvironmentSummary(logger, results)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := environmentsErr(results); err != nil {
+		return err
+	}
 	logger.Infof("Finished...")
+	return nil
+}
+
+func main() {
+	logger := loggerpkg.GetLogger()
+
+	// Cancel in-flight requests and pending retries on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := newRootCommand(logger).ExecuteContext(ctx); err != nil {
+		stop()
+		logger.Fatalf("Finished with errors: %v", err)
+	}
 }
@@ -2,18 +2,32 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/loggerpkg"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
 	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/sysdighttp"
 	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/notification"
 	"github.com/golang/mock/gomock"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSuite(t *testing.T) {
@@ -21,12 +35,22 @@ func TestSuite(t *testing.T) {
 	ginkgo.RunSpecs(t, "Main Suite")
 }
 
+// clusterTargets returns the targets discovered when grouping by cluster only
+func clusterTargets(clusterNames ...string) []target {
+	var targets []target
+	for _, clusterName := range clusterNames {
+		targets = append(targets, clusterTarget(clusterName))
+	}
+	return targets
+}
+
 var _ = ginkgo.Describe("Main", func() {
 	var (
 		ctrl             *gomock.Controller
 		mockSysdigClient *sysdighttp.MockSysdigClient
 		logger           *logrus.Logger
 		configManager    *configuration.ConfigManager
+		api              *secure.Client
 	)
 
 	ginkgo.BeforeEach(func() {
@@ -40,6 +64,7 @@ var _ = ginkgo.Describe("Main", func() {
 		if err != nil {
 			logger.Warnf("Could not load configuration. Error: '%v'", err)
 		}
+		api = secure.NewClient(logger, mockSysdigClient, configManager.GetConfig().SecureURL, configManager.GetConfig().SecureAPIToken)
 	})
 
 	ginkgo.AfterEach(func() {
@@ -53,7 +78,7 @@ var _ = ginkgo.Describe("Main", func() {
 			Body:       io.NopCloser(bytes.NewBufferString(mockResponse)),
 		}
 
-		mockSysdigClient.EXPECT().SysdigRequest(gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
 		mockSysdigClient.EXPECT().ResponseBodyToJson(httpResponse, gomock.Any()).DoAndReturn(func(resp *http.Response, target interface{}) error {
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
@@ -61,10 +86,10 @@ var _ = ginkgo.Describe("Main", func() {
 			}
 			return json.Unmarshal(body, target)
 		}).Times(1)
-		result, err := retrieveClusters(logger, configManager.GetConfig(), mockSysdigClient)
+		result, err := retrieveClusters(context.Background(), logger, configManager.GetConfig(), api)
 		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
 		gomega.Expect(len(result.Data)).Should(gomega.Equal(1))
-		gomega.Expect(result.Data[0].KubernetesClusterName).Should(gomega.Equal("aamiles-onprem5"))
+		gomega.Expect(result.Data[0].KubernetesClusterName()).Should(gomega.Equal("aamiles-onprem5"))
 	})
 
 	ginkgo.It("should get alerts successfully", func() {
@@ -75,7 +100,7 @@ var _ = ginkgo.Describe("Main", func() {
 			Body:       io.NopCloser(bytes.NewBufferString(mockResponse)),
 		}
 
-		mockSysdigClient.EXPECT().SysdigRequest(gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
 		mockSysdigClient.EXPECT().ResponseBodyToJson(httpResponse, gomock.Any()).DoAndReturn(func(resp *http.Response, target interface{}) error {
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
@@ -84,7 +109,7 @@ var _ = ginkgo.Describe("Main", func() {
 			return json.Unmarshal(body, target)
 		}).Times(1)
 
-		result, err := getAlerts(logger, configManager.GetConfig(), mockSysdigClient)
+		result, err := api.Alerts.List(context.Background())
 		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
 		gomega.Expect(len(result.Alerts)).Should(gomega.Equal(1))
 		gomega.Expect(result.Alerts[0].Name).Should(gomega.Equal("Cluster: aamiles-onprem5"))
@@ -93,21 +118,21 @@ var _ = ginkgo.Describe("Main", func() {
 	ginkgo.It("should detect if an alert exists", func() {
 		alerts := &alerts.AlertQuery{
 			Alerts: []alerts.Alert{
-				{Name: "Cluster: aamiles-onprem5", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+				{Name: "Cluster: aamiles-onprem5", Description: "[alerts-by-cluster instance=default cluster=aamiles-onprem5]", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
 			},
 		}
-		exists := alertExists(alerts, "aamiles-onprem5")
-		gomega.Expect(exists).Should(gomega.BeTrue())
+		alert := findAlert(alerts, owner{InstanceID: "default", Template: "default", Cluster: "aamiles-onprem5"})
+		gomega.Expect(alert).ShouldNot(gomega.BeNil())
 	})
 
 	ginkgo.It("should detect if an alert does not exist", func() {
 		alerts := &alerts.AlertQuery{
 			Alerts: []alerts.Alert{
-				{Name: "Cluster: other-cluster", Scope: "kubernetes.cluster.name = \"other-cluster\""},
+				{Name: "Cluster: other-cluster", Description: "[alerts-by-cluster instance=default cluster=other-cluster]", Scope: "kubernetes.cluster.name = \"other-cluster\""},
 			},
 		}
-		exists := alertExists(alerts, "aamiles-onprem5")
-		gomega.Expect(exists).Should(gomega.BeFalse())
+		alert := findAlert(alerts, owner{InstanceID: "default", Template: "default", Cluster: "aamiles-onprem5"})
+		gomega.Expect(alert).Should(gomega.BeNil())
 	})
 
 	ginkgo.It("should create alert for cluster", func() {
@@ -117,8 +142,777 @@ var _ = ginkgo.Describe("Main", func() {
 			Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
 		}
 
-		mockSysdigClient.EXPECT().SysdigRequest(gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
-		err := createAlertForCluster(logger, configManager.GetConfig(), clusterName, mockSysdigClient)
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(httpResponse, nil).Times(1)
+		mockSysdigClient.EXPECT().ResponseBodyToJson(httpResponse, gomock.Any()).Return(nil).Times(1)
+		plan, err := buildPlan(configManager.GetConfig(), nil, &alerts.AlertQuery{}, clusterTargets(clusterName))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.count(actionCreate)).Should(gomega.Equal(1))
+		report, err := applyPlan(context.Background(), logger, configManager.GetConfig(), plan, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(report.err()).ShouldNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should report no drift for an alert matching the desired payload", func() {
+		existing := alerts.Alert{AlertID: "1"}
+		rendered, err := renderAlert(configManager.GetConfig(), configuration.DefaultAlertTemplate(), clusterTarget("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		desired := rendered.Payload
+		existing.Enabled = desired.Enabled
+		existing.Type = desired.Type
+		existing.Name = desired.Name
+		existing.Description = desired.Description
+		existing.Scope = desired.Scope
+		existing.Triggers = desired.Triggers
+		gomega.Expect(changedFields(alertChanges(existing.Payload(), desired))).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should detect drifted alert fields", func() {
+		rendered, err := renderAlert(configManager.GetConfig(), configuration.DefaultAlertTemplate(), clusterTarget("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		desired := rendered.Payload
+		current := desired
+		current.Enabled = false
+		current.Triggers.VulnUpdate = false
+		current.NotificationChannelIds = []string{"42"}
+		gomega.Expect(changedFields(alertChanges(current, desired))).Should(gomega.Equal([]string{"enabled", "triggers", "notificationChannelIds"}))
+	})
+
+	ginkgo.It("should update a drifted alert", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "abc123", Enabled: false, Name: "Cluster: aamiles-onprem5", Description: "[alerts-by-cluster instance=default cluster=aamiles-onprem5]", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+			},
+		}
+		httpResponse := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+		}
+
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			gomega.Expect(config.Method).Should(gomega.Equal("PUT"))
+			gomega.Expect(config.ApiEndpoint).Should(gomega.HaveSuffix("/api/scanning/v1/alerts/abc123"))
+			return httpResponse, nil
+		}).Times(1)
+		mockSysdigClient.EXPECT().ResponseBodyToJson(httpResponse, gomock.Any()).Return(nil).Times(1)
+		plan, err := buildPlan(configManager.GetConfig(), nil, existing, clusterTargets("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.count(actionUpdate)).Should(gomega.Equal(1))
+		report, err := applyPlan(context.Background(), logger, configManager.GetConfig(), plan, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(report.err()).ShouldNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should only prune managed alerts for clusters that no longer exist", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "1", Name: "Cluster: aamiles-onprem5", Description: "[alerts-by-cluster instance=default cluster=aamiles-onprem5]", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+				{AlertID: "2", Name: "Cluster: old-cluster", Description: "[alerts-by-cluster instance=default cluster=old-cluster]", Scope: "kubernetes.cluster.name = \"old-cluster\""},
+				{AlertID: "3", Name: "Hand made", Scope: "kubernetes.cluster.name = \"other-cluster\""},
+			},
+		}
+		stale := staleAlerts(configManager.GetConfig(), existing, clusterTargets("aamiles-onprem5"))
+		gomega.Expect(len(stale)).Should(gomega.Equal(1))
+		gomega.Expect(stale[0].AlertID).Should(gomega.Equal("2"))
+	})
+
+	ginkgo.It("should delete stale alerts when the prune action is delete", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "2", Enabled: true, Name: "Cluster: old-cluster", Description: "[alerts-by-cluster instance=default cluster=old-cluster]", Scope: "kubernetes.cluster.name = \"old-cluster\""},
+			},
+		}
+		config := *configManager.GetConfig()
+		config.Prune.Action = configuration.PruneActionDelete
+		httpResponse := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+		}
+
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			gomega.Expect(config.Method).Should(gomega.Equal("DELETE"))
+			gomega.Expect(config.ApiEndpoint).Should(gomega.HaveSuffix("/api/scanning/v1/alerts/2"))
+			return httpResponse, nil
+		}).Times(1)
+		plan, err := prunePlan(&config, nil, existing, clusterTargets("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		report, err := applyPlan(context.Background(), logger, &config, plan, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(report.err()).ShouldNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should refuse to prune every managed alert when no clusters were discovered", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "1", Enabled: true, Name: "Cluster: aamiles-onprem5", Description: "[alerts-by-cluster instance=default cluster=aamiles-onprem5]", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+				{AlertID: "3", Name: "Hand made", Scope: "kubernetes.cluster.name = \"other-cluster\""},
+			},
+		}
+		config := *configManager.GetConfig()
+		config.Prune.Enabled = true
+
+		_, err := buildPlan(&config, nil, existing, clusterTargets())
+		gomega.Expect(err).Should(gomega.MatchError("refusing to prune 1 managed alerts as no clusters were discovered, set prune.allow_empty to prune them anyway"))
+		_, err = prunePlan(&config, nil, existing, clusterTargets())
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+
+		// Nothing to prune, nothing to refuse
+		plan, err := buildPlan(&config, nil, &alerts.AlertQuery{Alerts: existing.Alerts[1:]}, clusterTargets())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.Changes).Should(gomega.BeEmpty())
+
+		config.Prune.AllowEmpty = true
+		plan, err = buildPlan(&config, nil, existing, clusterTargets())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.count(actionDisable)).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should plan creates, updates and prunes without issuing requests", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "1", Enabled: false, Name: "Cluster: aamiles-onprem5", Description: "[alerts-by-cluster instance=default cluster=aamiles-onprem5]", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+				{AlertID: "2", Enabled: true, Name: "Cluster: old-cluster", Description: "[alerts-by-cluster instance=default cluster=old-cluster]", Scope: "kubernetes.cluster.name = \"old-cluster\""},
+			},
+		}
+		config := *configManager.GetConfig()
+		config.Prune.Enabled = true
+		config.Prune.Action = configuration.PruneActionDelete
+
+		plan, err := buildPlan(&config, nil, existing, clusterTargets("aamiles-onprem5", "new-cluster"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.count(actionUpdate)).Should(gomega.Equal(1))
+		gomega.Expect(plan.count(actionCreate)).Should(gomega.Equal(1))
+		gomega.Expect(plan.count(actionDelete)).Should(gomega.Equal(1))
+
+		var text bytes.Buffer
+		gomega.Expect(printPlan(&text, plan, configuration.OutputText)).Should(gomega.Succeed())
+		gomega.Expect(text.String()).Should(gomega.ContainSubstring("Plan: 1 to create, 1 to update, 0 to adopt, 0 to disable, 1 to delete."))
+
+		var output bytes.Buffer
+		gomega.Expect(printPlan(&output, plan, configuration.OutputJSON)).Should(gomega.Succeed())
+		var decoded syncPlan
+		gomega.Expect(json.Unmarshal(output.Bytes(), &decoded)).Should(gomega.Succeed())
+		gomega.Expect(len(decoded.Changes)).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("should not treat a hand-made alert with a matching scope as managed", func() {
+		alerts := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{Name: "Cluster: aamiles-onprem5", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+			},
+		}
+		gomega.Expect(findAlert(alerts, owner{InstanceID: "default", Template: "default", Cluster: "aamiles-onprem5"})).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should round trip the ownership marker", func() {
+		expected := owner{InstanceID: "prod", Template: "pci", Cluster: "cluster with spaces]"}
+		parsed, ok := parseOwnerMarker(withOwnerMarker(ownerMarker(expected), "Owned by the platform team"))
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(parsed).Should(gomega.Equal(expected))
+	})
+
+	ginkgo.It("should skip unmanaged alerts unless adopting", func() {
+		existing := &alerts.AlertQuery{
+			Alerts: []alerts.Alert{
+				{AlertID: "7", Enabled: true, Name: "Hand made", Description: "Created by hand", Scope: "kubernetes.cluster.name = \"aamiles-onprem5\""},
+			},
+		}
+		config := *configManager.GetConfig()
+		desired, err := renderAlert(&config, configuration.DefaultAlertTemplate(), clusterTarget("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		change, skipReason := planCluster(&config, existing, desired)
+		gomega.Expect(change).Should(gomega.BeNil())
+		gomega.Expect(skipReason).Should(gomega.ContainSubstring("--adopt"))
+
+		config.Adopt = true
+		change, _ = planCluster(&config, existing, desired)
+		gomega.Expect(change.Action).Should(gomega.Equal(actionAdopt))
+		gomega.Expect(change.Payload.Description).Should(gomega.Equal("[alerts-by-cluster instance=default template=default cluster=aamiles-onprem5] Created by hand"))
+	})
+
+	ginkgo.It("should recognise unmanaged alerts whose scope is spelled differently", func() {
+		config := *configManager.GetConfig()
+		desired, err := renderAlert(&config, configuration.DefaultAlertTemplate(), clusterTarget("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		for _, spelling := range []string{`kubernetes.cluster.name="aamiles-onprem5"`, `kubernetes.cluster.name = 'aamiles-onprem5'`, `kubernetes.cluster.name in ("aamiles-onprem5")`} {
+			existing := &alerts.AlertQuery{Alerts: []alerts.Alert{{AlertID: "7", Name: "Hand made", Scope: spelling}}}
+			change, skipReason := planCluster(&config, existing, desired)
+			gomega.Expect(change).Should(gomega.BeNil(), spelling)
+			gomega.Expect(skipReason).Should(gomega.ContainSubstring("--adopt"), spelling)
+		}
+	})
+
+	ginkgo.It("should not report reformatted scopes as drift", func() {
+		current := alerts.PayloadAlert{Scope: `kubernetes.cluster.name in ('aamiles-onprem5')`}
+		desired := alerts.PayloadAlert{Scope: `kubernetes.cluster.name = "aamiles-onprem5"`}
+		gomega.Expect(changedFields(alertChanges(current, desired))).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should escape cluster names in scopes", func() {
+		config := *configManager.GetConfig()
+		desired, err := renderAlert(&config, configuration.DefaultAlertTemplate(), clusterTarget(`odd"name`))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(desired.Payload.Scope).Should(gomega.Equal(`kubernetes.cluster.name = "odd\"name"`))
+
+		unquoted := configuration.DefaultAlertTemplate()
+		unquoted.Scope = "kubernetes.cluster.name = \"{{ .ClusterName }}\""
+		_, err = renderAlert(&config, unquoted, clusterTarget(`odd"name`))
+		gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("quote .ClusterName")))
+	})
+
+	ginkgo.It("should generate an alert per namespace when grouping by namespace", func() {
+		config := *configManager.GetConfig()
+		config.Discovery.GroupBy = []string{configuration.LabelClusterName, configuration.LabelNamespaceName}
+		config.Namespaces.Exclude = []string{"kube-*"}
+		discovered := &metadata.ResultMetadata{Data: []metadata.DataMetadataResult{
+			{configuration.LabelClusterName: "prod", configuration.LabelNamespaceName: "payments"},
+			{configuration.LabelClusterName: "prod", configuration.LabelNamespaceName: "kube-system"},
+			{configuration.LabelClusterName: "prod"},
+		}}
+
+		plan, err := buildPlan(&config, nil, &alerts.AlertQuery{}, targetsFromMetadata(&config, discovered, nil))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(plan.Changes)).Should(gomega.Equal(1))
+		gomega.Expect(plan.Changes[0].Group).Should(gomega.Equal("kubernetes.namespace.name=payments"))
+		gomega.Expect(plan.Changes[0].Payload.Name).Should(gomega.Equal("Cluster: prod / Namespace: payments"))
+		gomega.Expect(plan.Changes[0].Payload.Scope).Should(gomega.Equal(`kubernetes.cluster.name = "prod" and kubernetes.namespace.name = "payments"`))
+		gomega.Expect(plan.Skipped).Should(gomega.Equal([]skippedCluster{{Cluster: "prod", Group: "kubernetes.namespace.name=kube-system", Reason: "namespace excluded by rule 'kube-*'"}}))
+
+		o, ok := parseOwnerMarker(plan.Changes[0].Payload.Description)
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(o).Should(gomega.Equal(owner{InstanceID: "default", Template: "default", Cluster: "prod", Group: "kubernetes.namespace.name=payments"}))
+	})
+
+	ginkgo.It("should prune the alerts of namespaces that no longer exist", func() {
+		config := *configManager.GetConfig()
+		config.Discovery.GroupBy = []string{configuration.LabelClusterName, configuration.LabelNamespaceName}
+		existing := &alerts.AlertQuery{Alerts: []alerts.Alert{
+			{AlertID: "1", Description: ownerMarker(owner{InstanceID: "default", Template: "default", Cluster: "prod", Group: "kubernetes.namespace.name=payments"})},
+			{AlertID: "2", Description: ownerMarker(owner{InstanceID: "default", Template: "default", Cluster: "prod", Group: "kubernetes.namespace.name=retired"})},
+			{AlertID: "3", Description: "[alerts-by-cluster instance=default cluster=prod]"},
+		}}
+		targets := []target{{Cluster: inventory.Cluster{Name: "prod"}, Dimensions: []dimension{
+			{Key: configuration.LabelClusterName, Value: "prod"},
+			{Key: configuration.LabelNamespaceName, Value: "payments"},
+		}}}
+
+		var stale []string
+		for _, alert := range staleAlerts(&config, existing, targets) {
+			stale = append(stale, alert.AlertID)
+		}
+		gomega.Expect(stale).Should(gomega.Equal([]string{"2", "3"}))
+	})
+
+	ginkgo.It("should generate an alert per agent tag value when not grouping by cluster", func() {
+		config := *configManager.GetConfig()
+		config.Discovery.GroupBy = []string{"agent.tag.env"}
+		config.Clusters.Include = []string{"prod-*"}
+		discovered := &metadata.ResultMetadata{Data: []metadata.DataMetadataResult{{"agent.tag.env": "prod"}}}
+
+		plan, err := buildPlan(&config, nil, &alerts.AlertQuery{}, targetsFromMetadata(&config, discovered, nil))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(plan.Changes)).Should(gomega.Equal(1))
+		gomega.Expect(plan.Changes[0].Payload.Name).Should(gomega.Equal("Group: agent.tag.env=prod"))
+		gomega.Expect(plan.Changes[0].Payload.Scope).Should(gomega.Equal(`agent.tag.env = "prod"`))
+		gomega.Expect(plan.Changes[0].Payload.Description).Should(gomega.Equal("[alerts-by-cluster instance=default template=default group=agent.tag.env%3Dprod]"))
+
+		o, ok := parseOwnerMarker(plan.Changes[0].Payload.Description)
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(o).Should(gomega.Equal(owner{InstanceID: "default", Template: "default", Group: "agent.tag.env=prod"}))
+		_, ok = parseOwnerMarker("[alerts-by-cluster instance=default template=default]")
+		gomega.Expect(ok).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should render alerts from configured templates", func() {
+		config := *configManager.GetConfig()
+		config.Templates = []configuration.AlertTemplate{
+			{
+				ID:          "pci",
+				Type:        "runtime",
+				Name:        "PCI {{ .ClusterName }}",
+				Description: "Managed for {{ .ClusterName }}",
+				Scope:       "kubernetes.cluster.name = \"{{ .ClusterName }}\"",
+				Triggers:    configuration.TemplateTriggers{PolicyEval: true},
+			},
+		}
+
+		desired, err := desiredAlertsForTarget(&config, nil, clusterTarget("aamiles-onprem5"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(desired)).Should(gomega.Equal(1))
+		gomega.Expect(desired[0].Payload.Name).Should(gomega.Equal("PCI aamiles-onprem5"))
+		gomega.Expect(desired[0].Payload.Description).Should(gomega.Equal("[alerts-by-cluster instance=default template=pci cluster=aamiles-onprem5] Managed for aamiles-onprem5"))
+		gomega.Expect(desired[0].Payload.Triggers).Should(gomega.Equal(alerts.PayloadTriggers{PolicyEval: true}))
+		gomega.Expect(desired[0].Owner.Template).Should(gomega.Equal("pci"))
+	})
+
+	ginkgo.It("should skip clusters rejected by the selectors and report the rule", func() {
+		config := *configManager.GetConfig()
+		config.Clusters.Include = []string{"prod-*", "regex:^eu-[0-9]+$"}
+		config.Clusters.Exclude = []string{"prod-ci-*"}
+
+		plan, err := buildPlan(&config, nil, &alerts.AlertQuery{}, clusterTargets("prod-a", "prod-ci-1", "eu-1", "kind-local"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(plan.count(actionCreate)).Should(gomega.Equal(2))
+		gomega.Expect(plan.Skipped).Should(gomega.Equal([]skippedCluster{
+			{Cluster: "prod-ci-1", Reason: "excluded by rule 'prod-ci-*'"},
+			{Cluster: "kind-local", Reason: "not matched by any include rule"},
+		}))
+	})
+
+	ginkgo.It("should route notification channels by cluster selector", func() {
+		config := *configManager.GetConfig()
+		config.Routing = []configuration.NotificationRoute{
+			{Clusters: []string{"prod-*"}, Channels: []string{"PagerDuty Prod"}},
+			{Clusters: []string{"*"}, Channels: []string{"Slack"}},
+		}
+		channels := &notification.ChannelQuery{
+			NotificationChannels: []notification.Channel{{ID: 10, Name: "PagerDuty Prod"}, {ID: 20, Name: "Slack"}},
+		}
+
+		router, err := newChannelRouter(&config, channels)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		desired, err := desiredAlertsForTarget(&config, router, clusterTarget("prod-a"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(desired[0].Payload.NotificationChannelIds).Should(gomega.Equal([]string{"10", "20"}))
+		desired, err = desiredAlertsForTarget(&config, router, clusterTarget("dev-a"))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(desired[0].Payload.NotificationChannelIds).Should(gomega.Equal([]string{"20"}))
+	})
+
+	ginkgo.It("should expose agent tags to templates and routing", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload := metadata.PayloadMetadata{}
+			gomega.Expect(json.NewDecoder(r.Body).Decode(&payload)).Should(gomega.Succeed())
+			if len(payload.Metrics) > 1 {
+				gomega.Expect(payload.Metrics).Should(gomega.Equal([]string{"kubernetes.cluster.name", "agent.tag.env"}))
+				_, _ = io.WriteString(w, `{"data":[{"kubernetes.cluster.name":"payments","agent.tag.env":"prod"},{"kubernetes.cluster.name":"sandbox","agent.tag.env":"dev"}],"paging":{"from":0,"to":1,"total":2}}`)
+				return
+			}
+			_, _ = io.WriteString(w, `{"data":[{"kubernetes.cluster.name":"payments"},{"kubernetes.cluster.name":"sandbox"},{"kubernetes.cluster.name":"new"}],"paging":{"from":0,"to":2,"total":3}}`)
+		}))
+		defer backend.Close()
+
+		config := *configManager.GetConfig()
+		config.SecureURL = backend.URL
+		config.Discovery.Tags = []string{"env"}
+		config.Templates = []configuration.AlertTemplate{{ID: "default", Type: "runtime", Name: "{{ .Tags.env }}: {{ .ClusterName }}", Scope: "{{ .Scope }}"}}
+		config.Routing = []configuration.NotificationRoute{
+			{Tags: map[string]string{"env": "prod"}, Channels: []string{"PagerDuty Prod"}},
+			{Tags: map[string]string{"env": "dev"}, Channels: []string{"Slack Security"}},
+		}
+		channels := &notification.ChannelQuery{NotificationChannels: []notification.Channel{
+			{ID: 10, Name: "PagerDuty Prod"},
+			{ID: 20, Name: "Slack Security"},
+		}}
+		router, err := newChannelRouter(&config, channels)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		api, err := newAPI(logger, &config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		targets, err := discoverTargets(context.Background(), logger, &config, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		plan, err := buildPlan(&config, router, &alerts.AlertQuery{}, targets)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(plan.Changes)).Should(gomega.Equal(3))
+		gomega.Expect(plan.Changes[0].Payload.Name).Should(gomega.Equal("prod: payments"))
+		gomega.Expect(plan.Changes[0].Payload.NotificationChannelIds).Should(gomega.Equal([]string{"10"}))
+		gomega.Expect(plan.Changes[1].Payload.Name).Should(gomega.Equal("dev: sandbox"))
+		gomega.Expect(plan.Changes[1].Payload.NotificationChannelIds).Should(gomega.Equal([]string{"20"}))
+		// Clusters no agent reports tags for still render, with empty values
+		gomega.Expect(plan.Changes[2].Payload.Name).Should(gomega.Equal(": new"))
+		gomega.Expect(plan.Changes[2].Payload.NotificationChannelIds).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should merge cluster sources, earlier sources taking precedence", func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{"data":[{"kubernetes.cluster.name":"payments","agent.tag.env":"prod"},{"kubernetes.cluster.name":"sandbox"}],"paging":{"from":0,"to":1,"total":2}}`)
+		}))
+		defer backend.Close()
+
+		config := *configManager.GetConfig()
+		config.SecureURL = backend.URL
+		config.Discovery.Tags = []string{"env"}
+		config.Discovery.Sources = []configuration.Source{
+			{Type: configuration.SourceMetadata},
+			{Type: configuration.SourceStatic, Clusters: []configuration.StaticCluster{
+				{Name: "onboarding", Tags: map[string]string{"env": "staging"}},
+				{Name: "payments", Tags: map[string]string{"env": "dev"}},
+				{Name: "sandbox", Tags: map[string]string{"env": "dev"}},
+			}},
+		}
+		api, err := newAPI(logger, &config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		targets, err := discoverTargets(context.Background(), logger, &config, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		var clusters []inventory.Cluster
+		for _, t := range targets {
+			clusters = append(clusters, t.Cluster)
+		}
+		gomega.Expect(clusters).Should(gomega.Equal([]inventory.Cluster{
+			{Name: "payments", Source: "metadata", Tags: map[string]string{"env": "prod"}},
+			{Name: "sandbox", Source: "metadata", Tags: map[string]string{"env": "dev"}},
+			{Name: "onboarding", Source: "static", Tags: map[string]string{"env": "staging"}},
+		}))
+
+		config.Discovery.Sources = append(config.Discovery.Sources, configuration.Source{Type: configuration.SourceFile, Path: "/nonexistent/clusters.yaml"})
+		_, err = discoverTargets(context.Background(), logger, &config, api)
+		gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("could not retrieve clusters: cluster source 'file:/nonexistent/clusters.yaml'")))
+	})
+
+	ginkgo.It("should fail when a routed channel name does not exist or is ambiguous", func() {
+		config := *configManager.GetConfig()
+		config.Routing = []configuration.NotificationRoute{{Clusters: []string{"prod-*"}, Channels: []string{"Missing"}}}
+
+		_, err := newChannelRouter(&config, &notification.ChannelQuery{})
+		gomega.Expect(err).Should(gomega.MatchError("notification channel 'Missing' referenced by routing rule 0 does not exist"))
+
+		config.Routing = []configuration.NotificationRoute{{Clusters: []string{"prod-*"}, Channels: []string{"Slack"}}}
+		_, err = newChannelRouter(&config, &notification.ChannelQuery{NotificationChannels: []notification.Channel{{ID: 10, Name: "Slack"}, {ID: 20, Name: "Slack"}}})
+		gomega.Expect(err).Should(gomega.MatchError("2 notification channels are named 'Slack', referenced by routing rule 0, rename all but one of the IDs 10, 20"))
+	})
+
+	ginkgo.It("should page through cluster discovery and deduplicate cluster names", func() {
+		config := *configManager.GetConfig()
+		config.Discovery.PageSize = 2
+		pages := []string{
+			`{"data":[{"kubernetes.cluster.name":"cluster-a"},{"kubernetes.cluster.name":"cluster-b"}],"paging":{"from":0,"to":1,"total":3}}`,
+			`{"data":[{"kubernetes.cluster.name":"cluster-a"}],"paging":{"from":2,"to":3,"total":3}}`,
+		}
+
+		var requested []metadata.PagingPayload
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			requested = append(requested, config.JSON.(metadata.PayloadMetadata).Paging)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(pages[len(requested)-1])),
+			}, nil
+		}).Times(2)
+		mockSysdigClient.EXPECT().ResponseBodyToJson(gomock.Any(), gomock.Any()).DoAndReturn(func(resp *http.Response, target interface{}) error {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(body, target)
+		}).Times(2)
+
+		result, err := retrieveClusters(context.Background(), logger, &config, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(requested).Should(gomega.Equal([]metadata.PagingPayload{{From: 0, To: 1}, {From: 2, To: 3}}))
+		gomega.Expect(len(result.Data)).Should(gomega.Equal(2))
+		gomega.Expect(result.Data[1].KubernetesClusterName()).Should(gomega.Equal("cluster-b"))
+	})
+
+	ginkgo.It("should send the configured lookback as the discovery time range", func() {
+		config := *configManager.GetConfig()
+		config.Discovery.Lookback = "7d"
+		httpResponse := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"data":[],"paging":{"from":0,"to":0,"total":0}}`)),
+		}
+
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			timeRange := config.JSON.(metadata.PayloadMetadata).Time
+			gomega.Expect(timeRange).ShouldNot(gomega.BeNil())
+			gomega.Expect(timeRange.To - timeRange.From).Should(gomega.Equal((7 * 24 * time.Hour).Microseconds()))
+			return httpResponse, nil
+		}).Times(1)
+		mockSysdigClient.EXPECT().ResponseBodyToJson(httpResponse, gomock.Any()).Return(nil).Times(1)
+
+		_, err := retrieveClusters(context.Background(), logger, &config, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should parse lookbacks in days and Go durations", func() {
+		lookback, err := configuration.ParseLookback("12h")
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(lookback).Should(gomega.Equal(12 * time.Hour))
+		_, err = configuration.ParseLookback("-1d")
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should stop applying the plan once the context is cancelled", func() {
+		plan := syncPlan{Changes: []plannedChange{
+			{Action: actionDelete, Cluster: "a", AlertID: "1"},
+			{Action: actionDelete, Cluster: "b", AlertID: "2"},
+		}}
+		ctx, cancel := context.WithCancel(context.Background())
+		httpResponse := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+		}
+
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			cancel()
+			return httpResponse, nil
+		}).Times(1)
+		config := *configManager.GetConfig()
+		config.Workers = 1
+		report, err := applyPlan(ctx, logger, &config, plan, api)
+		gomega.Expect(err).Should(gomega.MatchError(context.Canceled))
+		gomega.Expect(report.count(statusApplied)).Should(gomega.Equal(1))
+		gomega.Expect(report.count(statusNotApplied)).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should carry on past failed changes and report the failed clusters", func() {
+		plan := syncPlan{
+			Changes: []plannedChange{
+				{Action: actionDelete, Cluster: "bad", AlertID: "1"},
+				{Action: actionDelete, Cluster: "good", AlertID: "2"},
+			},
+			Failed: []clusterFailure{{Cluster: "broken-template", Error: "could not render template"}},
+		}
+
+		gomock.InOrder(
+			mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+				&http.Response{StatusCode: http.StatusConflict, Body: io.NopCloser(bytes.NewBufferString(""))},
+				fmt.Errorf("HTTP request failed with status code: 409")).Times(1),
+			mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+				&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(""))}, nil).Times(1),
+		)
+		config := *configManager.GetConfig()
+		config.Workers = 1
+		report, err := applyPlan(context.Background(), logger, &config, plan, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(report.count(statusApplied)).Should(gomega.Equal(1))
+		gomega.Expect(report.count(statusFailed)).Should(gomega.Equal(1))
+		gomega.Expect(report.failedClusters()).Should(gomega.Equal([]string{"bad", "broken-template"}))
+		gomega.Expect(report.err()).Should(gomega.MatchError("2 cluster(s) failed to sync: bad, broken-template"))
+	})
+
+	ginkgo.It("should apply changes concurrently and report them in plan order", func() {
+		plan := syncPlan{}
+		for i := 0; i < 20; i++ {
+			plan.Changes = append(plan.Changes, plannedChange{Action: actionDelete, Cluster: fmt.Sprintf("cluster-%02d", i), AlertID: strconv.Itoa(i)})
+		}
+
+		var inFlight, maxInFlight int32
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, logger *logrus.Logger, config sysdighttp.SysdigRequestConfig) (*http.Response, error) {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				seen := atomic.LoadInt32(&maxInFlight)
+				if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			if strings.HasSuffix(config.ApiEndpoint, "/7") {
+				return &http.Response{StatusCode: http.StatusConflict, Body: io.NopCloser(bytes.NewBufferString(""))}, fmt.Errorf("HTTP request failed with status code: 409")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		}).Times(20)
+
+		config := *configManager.GetConfig()
+		config.Workers = 4
+		report, err := applyPlan(context.Background(), logger, &config, plan, api)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(atomic.LoadInt32(&maxInFlight)).Should(gomega.BeNumerically(">", 1))
+		gomega.Expect(atomic.LoadInt32(&maxInFlight)).Should(gomega.BeNumerically("<=", 4))
+		for i, result := range report.Results {
+			gomega.Expect(result.Change.AlertID).Should(gomega.Equal(strconv.Itoa(i)))
+		}
+		gomega.Expect(report.failedClusters()).Should(gomega.Equal([]string{"cluster-07"}))
+	})
+
+	ginkgo.It("should plan the other clusters when a template fails to render for one", func() {
+		config := *configManager.GetConfig()
+		config.Templates = []configuration.AlertTemplate{{
+			ID:    "default",
+			Name:  "Cluster: {{ if eq .ClusterName \"bad\" }}{{ .Missing }}{{ else }}{{ .ClusterName }}{{ end }}",
+			Scope: "kubernetes.cluster.name = \"{{ .ClusterName }}\"",
+		}}
+
+		plan, err := buildPlan(&config, nil, &alerts.AlertQuery{}, clusterTargets("bad", "good"))
 		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(plan.Changes)).Should(gomega.Equal(1))
+		gomega.Expect(plan.Changes[0].Cluster).Should(gomega.Equal("good"))
+		gomega.Expect(len(plan.Failed)).Should(gomega.Equal(1))
+		gomega.Expect(plan.Failed[0].Cluster).Should(gomega.Equal("bad"))
+	})
+
+	ginkgo.It("should return metadata query failures instead of exiting", func() {
+		mockSysdigClient.EXPECT().SysdigRequestWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			&http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(bytes.NewBufferString(""))},
+			fmt.Errorf("HTTP request failed with status code: 401")).Times(1)
+
+		_, err := retrieveClusters(context.Background(), logger, configManager.GetConfig(), api)
+		gomega.Expect(errors.Is(err, secure.ErrUnauthorized)).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should resolve environments, falling back to the top-level settings", func() {
+		ginkgo.GinkgoT().Setenv("EU_SECURE_API_TOKEN", "eu-token")
+		config := *configManager.GetConfig()
+		config.Clusters = configuration.ClusterSelectors{Exclude: []string{"ci-*"}}
+		config.Environments = []configuration.Environment{
+			{Name: "us", SecureURL: "https://us.example.com", SecureAPIToken: "us-token"},
+			{Name: "eu", SecureURL: "https://eu.example.com", SecureAPITokenEnv: "EU_SECURE_API_TOKEN", Clusters: &configuration.ClusterSelectors{Include: []string{"eu-*"}}},
+		}
+
+		environments := config.ResolveEnvironments()
+		gomega.Expect(len(environments)).Should(gomega.Equal(2))
+		gomega.Expect(environments[0].Name).Should(gomega.Equal("us"))
+		gomega.Expect(environments[0].Config.SecureAPIToken).Should(gomega.Equal("us-token"))
+		gomega.Expect(environments[0].Config.Clusters.Exclude).Should(gomega.Equal([]string{"ci-*"}))
+		gomega.Expect(environments[0].Config.Templates).Should(gomega.Equal(config.Templates))
+		gomega.Expect(environments[1].Config.SecureURL).Should(gomega.Equal("https://eu.example.com"))
+		gomega.Expect(environments[1].Config.SecureAPIToken).Should(gomega.Equal("eu-token"))
+		gomega.Expect(environments[1].Config.Clusters.Include).Should(gomega.Equal([]string{"eu-*"}))
+		gomega.Expect(environments[1].Config.Clusters.Exclude).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should sync every environment and group the plans by environment", func() {
+		newBackend := func(clusterName string, status int) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				switch r.URL.Path {
+				case "/api/data/entity/metadata":
+					_, _ = fmt.Fprintf(w, `{"data":[{"kubernetes.cluster.name":"%s"}],"paging":{"from":0,"to":0,"total":1}}`, clusterName)
+				case "/api/scanning/v1/alerts":
+					_, _ = io.WriteString(w, `{"alerts":[]}`)
+				}
+			}))
+		}
+		us := newBackend("us-1", http.StatusOK)
+		defer us.Close()
+		eu := newBackend("eu-1", http.StatusUnauthorized)
+		defer eu.Close()
+
+		config := *configManager.GetConfig()
+		config.DryRun = true
+		config.Parallel = true
+		config.Environments = []configuration.Environment{
+			{Name: "us", SecureURL: us.URL, SecureAPIToken: "us-token"},
+			{Name: "eu", SecureURL: eu.URL, SecureAPIToken: "eu-token"},
+		}
+
+		results := syncEnvironments(context.Background(), logger, &config, buildPlan)
+		gomega.Expect(results[0].Name).Should(gomega.Equal("us"))
+		gomega.Expect(results[0].Err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(results[0].Plan.Changes[0].Cluster).Should(gomega.Equal("us-1"))
+		gomega.Expect(results[1].Name).Should(gomega.Equal("eu"))
+		gomega.Expect(errors.Is(results[1].Err, secure.ErrUnauthorized)).Should(gomega.BeTrue())
+
+		var out bytes.Buffer
+		gomega.Expect(printPlans(&out, results, configuration.OutputJSON)).Should(gomega.Succeed())
+		var plans []map[string]interface{}
+		gomega.Expect(json.Unmarshal(out.Bytes(), &plans)).Should(gomega.Succeed())
+		gomega.Expect(plans[0]["environment"]).Should(gomega.Equal("us"))
+		gomega.Expect(plans[0]["changes"]).Should(gomega.HaveLen(1))
+		gomega.Expect(plans[1]["error"]).Should(gomega.ContainSubstring("could not retrieve clusters"))
+		gomega.Expect(environmentsErr(results)).Should(gomega.MatchError(gomega.HavePrefix("environment 'eu': could not retrieve clusters")))
+	})
+
+	ginkgo.Describe("commands", func() {
+		var backend *httptest.Server
+		var deleted []string
+
+		execute := func(args ...string) (string, error) {
+			var out bytes.Buffer
+			cmd := newRootCommand(logger)
+			cmd.SetOut(&out)
+			cmd.SetErr(io.Discard)
+			cmd.SetArgs(args)
+			err := cmd.ExecuteContext(context.Background())
+			return out.String(), err
+		}
+
+		ginkgo.BeforeEach(func() {
+			deleted = nil
+			marker := ownerMarker(owner{InstanceID: "default", Template: "default", Cluster: "prod-1"})
+			backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/scanning/v1/alerts":
+					_ = json.NewEncoder(w).Encode(alerts.AlertQuery{Alerts: []alerts.Alert{
+						{AlertID: "1", Name: "Cluster: prod-1", Enabled: true, Description: marker, Scope: `kubernetes.cluster.name = "prod-1"`},
+						{AlertID: "2", Name: "Hand made", Enabled: true, Scope: `kubernetes.cluster.name = "legacy"`},
+					}})
+				case r.Method == http.MethodDelete:
+					deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/scanning/v1/alerts/"))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			// Flags bound by a command stay bound in viper, reset it so they do not leak into other specs
+			ginkgo.DeferCleanup(viper.Reset)
+			ginkgo.DeferCleanup(logger.SetOutput, os.Stdout)
+			ginkgo.DeferCleanup(backend.Close)
+		})
+
+		ginkgo.It("should print the version without any configuration", func() {
+			out, err := execute("version")
+			gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+			gomega.Expect(out).Should(gomega.Equal(fmt.Sprintf("alerts-by-cluster %s\n", VERSION)))
+		})
+
+		ginkgo.It("should list the managed alerts", func() {
+			out, err := execute("alerts", "list", "--managed", "--output", "json", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+			var listings []alertListing
+			gomega.Expect(json.Unmarshal([]byte(out), &listings)).Should(gomega.Succeed())
+			gomega.Expect(listings).Should(gomega.Equal([]alertListing{{ID: "1", Name: "Cluster: prod-1", Enabled: true, Scope: `kubernetes.cluster.name = "prod-1"`, Managed: true, Template: "default", Cluster: "prod-1"}}))
+		})
+
+		ginkgo.It("should keep the logs of loading the configuration out of JSON output", func() {
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			out, err := execute("sync", "--dry-run", "--output", "json", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(out).Should(gomega.BeEmpty())
+			gomega.Expect(logs.String()).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("should reject selectors on labels alerts are not grouped by", func() {
+			_, err := execute("plan", "--group_by", "agent.tag.env", "--exclude", "prod-*", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("cluster selectors require discovery.group_by to include kubernetes.cluster.name")))
+
+			_, err = execute("plan", "--include_namespaces", "payments", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("namespace selectors require discovery.group_by to include kubernetes.namespace.name")))
+		})
+
+		ginkgo.It("should reject a discovery filter that cluster sources would bypass", func() {
+			viper.Set("discovery.sources", []map[string]interface{}{{"type": "static", "clusters": []map[string]interface{}{{"name": "prod-1"}}}})
+			_, err := execute("plan", "--filter", `agent.tag.env = "prod"`, "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("static cluster source 0 cannot apply discovery.filter")))
+		})
+
+		ginkgo.It("should accept the discovery flags of sync when pruning", func() {
+			_, err := execute("prune", "--dry-run", "--group_by", "kubernetes.cluster.name,kubernetes.namespace.name", "--tags", "env", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("could not retrieve clusters")))
+			gomega.Expect(viper.GetStringSlice("discovery.group_by")).Should(gomega.Equal([]string{"kubernetes.cluster.name", "kubernetes.namespace.name"}))
+		})
+
+		ginkgo.It("should refuse to delete unmanaged alerts without --force", func() {
+			_, err := execute("alerts", "delete", "Hand made", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("use --force")))
+			gomega.Expect(deleted).Should(gomega.BeEmpty())
+
+			_, err = execute("alerts", "delete", "Cluster: prod-1", "--secure_url", backend.URL, "--secure_api_token", "token")
+			gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+			gomega.Expect(deleted).Should(gomega.Equal([]string{"1"}))
+		})
+
+		ginkgo.It("should plan the import of exported alerts", func() {
+			existing := &alerts.AlertQuery{Alerts: []alerts.Alert{{AlertID: "1", Name: "Hand made", Enabled: true}}}
+			imported := &alerts.AlertQuery{Alerts: []alerts.Alert{
+				{AlertID: "9", Name: "Hand made", Enabled: false},
+				{AlertID: "8", Name: "New alert", Enabled: true},
+			}}
+
+			plan, err := planImport(existing, imported)
+			gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+			gomega.Expect(len(plan.Changes)).Should(gomega.Equal(2))
+			gomega.Expect(plan.Changes[0].Action).Should(gomega.Equal(actionUpdate))
+			gomega.Expect(plan.Changes[0].AlertID).Should(gomega.Equal("1"))
+			gomega.Expect(plan.Changes[1].Action).Should(gomega.Equal(actionCreate))
+
+			existing.Alerts = append(existing.Alerts, alerts.Alert{AlertID: "2", Name: "Hand made", Enabled: true})
+			_, err = planImport(existing, imported)
+			gomega.Expect(err).Should(gomega.MatchError("could not import alert 'Hand made': 2 alerts are named 'Hand made', use one of the IDs 1, 2"))
+		})
 	})
 })
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ownerMarkerRegex matches the ownership marker written at the start of the description of every managed alert.
+// Markers written before templates were introduced carry no template and belong to the default template.  Only alerts
+// generated for a group, such as a namespace, carry a group, and alerts for a group of clusters carry no cluster.
+var ownerMarkerRegex = regexp.MustCompile(`^\[alerts-by-cluster instance=([^\s\]]+)(?: template=([^\s\]]+))?(?: cluster=([^\s\]]+))?(?: group=([^\s\]]+))?\]`)
+
+// owner identifies the tool instance, template, cluster and group within the cluster a managed alert belongs to
+type owner struct {
+	InstanceID string
+	Template   string
+	Cluster    string
+	Group      string
+}
+
+// ownerMarker returns the machine-readable marker identifying an alert as managed by this tool
+func ownerMarker(o owner) string {
+	marker := fmt.Sprintf("[alerts-by-cluster instance=%s template=%s", url.QueryEscape(o.InstanceID), url.QueryEscape(o.Template))
+	if o.Cluster != "" {
+		marker += " cluster=" + url.QueryEscape(o.Cluster)
+	}
+	if o.Group != "" {
+		marker += " group=" + url.QueryEscape(o.Group)
+	}
+	return marker + "]"
+}
+
+// parseOwnerMarker extracts the owner from an alert description, if it carries a marker
+func parseOwnerMarker(description string) (owner, bool) {
+	matches := ownerMarkerRegex.FindStringSubmatch(description)
+	if matches == nil {
+		return owner{}, false
+	}
+	if matches[2] == "" {
+		matches[2] = configuration.DefaultTemplateID
+	}
+	var o owner
+	for i, target := range []*string{&o.InstanceID, &o.Template, &o.Cluster, &o.Group} {
+		value, err := url.QueryUnescape(matches[i+1])
+		if err != nil {
+			return owner{}, false
+		}
+		*target = value
+	}
+	if o.Cluster == "" && o.Group == "" {
+		return owner{}, false
+	}
+	return o, true
+}
+
+// withOwnerMarker prefixes a description with the marker, replacing any marker already present
+func withOwnerMarker(marker string, description string) string {
+	description = strings.TrimSpace(ownerMarkerRegex.ReplaceAllString(description, ""))
+	if description == "" {
+		return marker
+	}
+	return fmt.Sprintf("%s %s", marker, description)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+)
+
+const (
+	actionCreate  = "create"
+	actionUpdate  = "update"
+	actionDisable = "disable"
+	actionDelete  = "delete"
+	actionAdopt   = "adopt"
+)
+
+type fieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type plannedChange struct {
+	Action   string              `json:"action"`
+	Cluster  string              `json:"cluster"`
+	Group    string              `json:"group,omitempty"`
+	Template string              `json:"template"`
+	AlertID  string              `json:"alertId,omitempty"`
+	Name     string              `json:"name"`
+	Changes  []fieldChange       `json:"changes,omitempty"`
+	Payload  alerts.PayloadAlert `json:"-"`
+}
+
+// describe returns the cluster, and group within the cluster, the change applies to
+func (c plannedChange) describe() string {
+	return describeTarget(c.Cluster, c.Group)
+}
+
+type skippedCluster struct {
+	Cluster  string `json:"cluster"`
+	Group    string `json:"group,omitempty"`
+	Template string `json:"template,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+func (s skippedCluster) describe() string {
+	if s.Template == "" {
+		return describeTarget(s.Cluster, s.Group)
+	}
+	return fmt.Sprintf("%s template '%s'", describeTarget(s.Cluster, s.Group), s.Template)
+}
+
+// clusterFailure records a cluster, or group within a cluster, whose alerts could not be planned, so that the rest of
+// the run can carry on
+type clusterFailure struct {
+	Cluster string `json:"cluster"`
+	Group   string `json:"group,omitempty"`
+	Error   string `json:"error"`
+}
+
+type syncPlan struct {
+	Changes []plannedChange  `json:"changes"`
+	Skipped []skippedCluster `json:"skipped,omitempty"`
+	Failed  []clusterFailure `json:"failed,omitempty"`
+}
+
+// count returns the number of planned changes with the given action
+func (p syncPlan) count(action string) int {
+	total := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			total++
+		}
+	}
+	return total
+}
+
+// buildPlan computes the create/update/disable/delete set needed to bring the existing alerts in line with the discovered
+// targets.  Targets rejected by the cluster or namespace selectors are reported as skipped but still count as existing
+// when pruning, so excluding a cluster never removes its alerts.  Targets whose templates fail to render are
+// reported as failed without stopping the plan for the others.
+func buildPlan(config *configuration.Config, router *channelRouter, existing *alerts.AlertQuery, targets []target) (syncPlan, error) {
+	plan := syncPlan{Changes: []plannedChange{}}
+	filter, err := newTargetFilter(config)
+	if err != nil {
+		return syncPlan{}, err
+	}
+
+	for _, t := range targets {
+		if selected, reason := filter.Evaluate(t); !selected {
+			plan.Skipped = append(plan.Skipped, skippedCluster{Cluster: t.Cluster.Name, Group: t.group(), Reason: reason})
+			continue
+		}
+		desired, err := desiredAlertsForTarget(config, router, t)
+		if err != nil {
+			plan.Failed = append(plan.Failed, clusterFailure{Cluster: t.Cluster.Name, Group: t.group(), Error: err.Error()})
+			continue
+		}
+		for _, alert := range desired {
+			change, skipReason := planCluster(config, existing, alert)
+			if change != nil {
+				plan.Changes = append(plan.Changes, *change)
+			} else if skipReason != "" {
+				plan.Skipped = append(plan.Skipped, skippedCluster{Cluster: t.Cluster.Name, Group: t.group(), Template: alert.Owner.Template, Reason: skipReason})
+			}
+		}
+	}
+	if config.Prune.Enabled {
+		changes, err := planPrune(config, existing, targets)
+		if err != nil {
+			return syncPlan{}, err
+		}
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
+
+// applyChange issues the mutating request for a single planned change
+func applyChange(ctx context.Context, logger *logrus.Logger, config *configuration.Config, change plannedChange, api *secure.Client) error {
+	var err error
+	switch change.Action {
+	case actionCreate:
+		logger.Debugf("Alert for %s does not exist, creating alert '%s' with scope '%s'", change.describe(), change.Payload.Name, change.Payload.Scope)
+		_, err = api.Alerts.Create(ctx, change.Payload)
+		return err
+	case actionUpdate:
+		logger.Infof("Alert '%s' (%s) for %s has drifted in %v, updating..", change.Name, change.AlertID, change.describe(), changedFields(change.Changes))
+		_, err = api.Alerts.Update(ctx, change.AlertID, change.Payload)
+		return err
+	case actionDisable:
+		logger.Infof("Alert '%s' (%s) for %s is no longer needed, disabling..", change.Name, change.AlertID, change.describe())
+		_, err = api.Alerts.Update(ctx, change.AlertID, change.Payload)
+		return err
+	case actionAdopt:
+		logger.Infof("Adopting unmanaged alert '%s' (%s) for %s..", change.Name, change.AlertID, change.describe())
+		_, err = api.Alerts.Update(ctx, change.AlertID, change.Payload)
+		return err
+	case actionDelete:
+		logger.Infof("Alert '%s' (%s) for %s is no longer needed, deleting..", change.Name, change.AlertID, change.describe())
+		return api.Alerts.Delete(ctx, change.AlertID)
+	}
+	return fmt.Errorf("unknown plan action '%s'", change.Action)
+}
+
+// applyPlan applies the planned changes using config.Workers concurrent workers.  A failed change is recorded in the
+// report and the run carries on with the others, so that one bad cluster does not stop the rest.  No new change is
+// started once the context is cancelled, and the context's error is returned.  Each change gets its own deadline when
+// config.OperationTimeout is set.  The report lists the results in plan order, whatever order they completed in.
+func applyPlan(ctx context.Context, logger *logrus.Logger, config *configuration.Config, plan syncPlan, api *secure.Client) (syncReport, error) {
+	results := make([]changeResult, len(plan.Changes))
+	for i, change := range plan.Changes {
+		results[i] = changeResult{Change: change, Status: statusNotApplied}
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = applyPlannedChange(ctx, logger, config, plan.Changes[i], api)
+			}
+		}()
+	}
+
+feed:
+	for i := range plan.Changes {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return syncReport{Results: results, Failed: plan.Failed}, ctx.Err()
+}
+
+// applyPlannedChange applies a single change under its own deadline, reporting it as not applied when the run is
+// cancelled before or while it is sent
+func applyPlannedChange(ctx context.Context, logger *logrus.Logger, config *configuration.Config, change plannedChange, api *secure.Client) changeResult {
+	if ctx.Err() != nil {
+		return changeResult{Change: change, Status: statusNotApplied}
+	}
+
+	opCtx, cancel := ctx, context.CancelFunc(func() {})
+	if config.OperationTimeout > 0 {
+		opCtx, cancel = context.WithTimeout(ctx, config.OperationTimeout)
+	}
+	defer cancel()
+	if err := applyChange(opCtx, logger, config, change, api); err != nil {
+		if ctx.Err() != nil {
+			return changeResult{Change: change, Status: statusNotApplied}
+		}
+		err = fmt.Errorf("could not %s alert '%s' for %s: %v", change.Action, change.Name, change.describe(), err)
+		logger.Error(err)
+		return changeResult{Change: change, Status: statusFailed, Err: err}
+	}
+	return changeResult{Change: change, Status: statusApplied}
+}
+
+// printPlan writes the plan in a Terraform-style human-readable format, or as JSON for CI pipelines
+func printPlan(w io.Writer, plan syncPlan, format string) error {
+	if format == configuration.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	for _, skipped := range plan.Skipped {
+		if _, err := fmt.Fprintf(w, "  # skip %s: %s\n", skipped.describe(), skipped.Reason); err != nil {
+			return err
+		}
+	}
+	for _, failed := range plan.Failed {
+		if _, err := fmt.Fprintf(w, "  ! error %s: %s\n", describeTarget(failed.Cluster, failed.Group), failed.Error); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes. Alerts are up to date.")
+		return err
+	}
+
+	symbols := map[string]string{
+		actionCreate:  "+",
+		actionUpdate:  "~",
+		actionDisable: "~",
+		actionDelete:  "-",
+		actionAdopt:   "~",
+	}
+	for _, change := range plan.Changes {
+		id := change.AlertID
+		if id == "" {
+			id = "new"
+		}
+		if _, err := fmt.Fprintf(w, "  %s %s \"%s\" (%s) for %s from template '%s'\n", symbols[change.Action], change.Action, change.Name, id, change.describe(), change.Template); err != nil {
+			return err
+		}
+		for _, field := range change.Changes {
+			if _, err := fmt.Fprintf(w, "      ~ %s: %v -> %v\n", field.Field, field.Before, field.After); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to adopt, %d to disable, %d to delete.\n",
+		plan.count(actionCreate), plan.count(actionUpdate), plan.count(actionAdopt), plan.count(actionDisable), plan.count(actionDelete))
+	return err
+}
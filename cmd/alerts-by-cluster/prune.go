@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+)
+
+// managedOwner returns the owner of an alert, only if it carries this instance's ownership marker.  Hand-made
+// alerts and alerts owned by other instances are never reported as managed.
+func managedOwner(alert alerts.Alert, instanceID string) (owner, bool) {
+	o, ok := parseOwnerMarker(alert.Description)
+	if !ok || o.InstanceID != instanceID {
+		return owner{}, false
+	}
+	return o, true
+}
+
+// staleAlerts returns the managed alerts whose cluster, or group within the cluster, is not among the discovered
+// targets, or whose template is no longer configured
+func staleAlerts(config *configuration.Config, existing *alerts.AlertQuery, targets []target) []alerts.Alert {
+	discovered := make(map[owner]bool, len(targets))
+	for _, t := range targets {
+		discovered[owner{Cluster: t.Cluster.Name, Group: t.group()}] = true
+	}
+	templates := make(map[string]bool, len(config.Templates))
+	for _, alertTemplate := range config.Templates {
+		templates[alertTemplate.ID] = true
+	}
+
+	var stale []alerts.Alert
+	for _, alert := range existing.Alerts {
+		o, managed := managedOwner(alert, config.InstanceID)
+		if managed && (!discovered[owner{Cluster: o.Cluster, Group: o.Group}] || !templates[o.Template]) {
+			stale = append(stale, alert)
+		}
+	}
+	return stale
+}
+
+// planPrune returns the disable or delete changes, depending on config.Prune.Action, for stale managed alerts.  An
+// empty discovery more likely means the cluster source failed quietly, e.g. a lookback without data or an empty
+// inventory, than that every cluster is gone, so pruning every managed alert is refused unless
+// config.Prune.AllowEmpty is set.
+func planPrune(config *configuration.Config, existing *alerts.AlertQuery, targets []target) ([]plannedChange, error) {
+	stale := staleAlerts(config, existing, targets)
+	if len(targets) == 0 && len(stale) > 0 && !config.Prune.AllowEmpty {
+		return nil, fmt.Errorf("refusing to prune %d managed alerts as no clusters were discovered, set prune.allow_empty to prune them anyway", len(stale))
+	}
+
+	var changes []plannedChange
+	for _, alert := range stale {
+		o, _ := managedOwner(alert, config.InstanceID)
+		if config.Prune.Action == configuration.PruneActionDelete {
+			changes = append(changes, plannedChange{Action: actionDelete, Cluster: o.Cluster, Group: o.Group, Template: o.Template, AlertID: alert.AlertID, Name: alert.Name})
+			continue
+		}
+		if !alert.Enabled {
+			continue
+		}
+		payload := alert.Payload()
+		payload.Enabled = false
+		changes = append(changes, plannedChange{
+			Action:   actionDisable,
+			Cluster:  o.Cluster,
+			Group:    o.Group,
+			Template: o.Template,
+			AlertID:  alert.AlertID,
+			Name:     alert.Name,
+			Changes:  []fieldChange{{Field: "enabled", Before: true, After: false}},
+			Payload:  payload,
+		})
+	}
+	return changes, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"sort"
+)
+
+// alertChanges compares an existing alert against the desired payload and returns every field that differs.  Scopes
+// are compared by meaning, so a scope the backend or a user reformatted is not reported as drift.
+func alertChanges(current alerts.PayloadAlert, desired alerts.PayloadAlert) []fieldChange {
+	var changes []fieldChange
+	add := func(field string, before interface{}, after interface{}) {
+		changes = append(changes, fieldChange{Field: field, Before: before, After: after})
+	}
+	if current.Enabled != desired.Enabled {
+		add("enabled", current.Enabled, desired.Enabled)
+	}
+	if current.Type != desired.Type {
+		add("type", current.Type, desired.Type)
+	}
+	if current.Name != desired.Name {
+		add("name", current.Name, desired.Name)
+	}
+	if current.Description != desired.Description {
+		add("description", current.Description, desired.Description)
+	}
+	if !scope.EqualStrings(current.Scope, desired.Scope) {
+		add("scope", current.Scope, desired.Scope)
+	}
+	if !equalStringSets(current.Repositories, desired.Repositories) {
+		add("repositories", current.Repositories, desired.Repositories)
+	}
+	if current.Triggers != desired.Triggers {
+		add("triggers", current.Triggers, desired.Triggers)
+	}
+	if current.Autoscan != desired.Autoscan {
+		add("autoscan", current.Autoscan, desired.Autoscan)
+	}
+	if current.OnlyPassFail != desired.OnlyPassFail {
+		add("onlyPassFail", current.OnlyPassFail, desired.OnlyPassFail)
+	}
+	if !equalStringSets(current.NotificationChannelIds, desired.NotificationChannelIds) {
+		add("notificationChannelIds", current.NotificationChannelIds, desired.NotificationChannelIds)
+	}
+	return changes
+}
+
+// changedFields returns the names of the changed fields
+func changedFields(changes []fieldChange) []string {
+	var fields []string
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	return fields
+}
+
+// equalStringSets treats nil and empty slices as equal and ignores ordering
+func equalStringSets(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// planCluster returns the change needed to create, update or adopt a desired alert.  It returns a nil change
+// when the alert is up to date, along with a reason when the alert has been skipped.
+func planCluster(config *configuration.Config, existing *alerts.AlertQuery, desired desiredAlert) (*plannedChange, string) {
+	o := desired.Owner
+
+	alert := findAlert(existing, o)
+	if alert == nil {
+		if unmanaged := findUnmanagedAlert(existing, desired.Payload.Scope); unmanaged != nil {
+			if !config.Adopt {
+				return nil, fmt.Sprintf("unmanaged alert '%s' (%s) already has this scope, run with --adopt to manage it", unmanaged.Name, unmanaged.AlertID)
+			}
+			payload := unmanaged.Payload()
+			payload.Description = withOwnerMarker(ownerMarker(o), unmanaged.Description)
+			return &plannedChange{
+				Action:   actionAdopt,
+				Cluster:  o.Cluster,
+				Group:    o.Group,
+				Template: o.Template,
+				AlertID:  unmanaged.AlertID,
+				Name:     unmanaged.Name,
+				Changes:  []fieldChange{{Field: "description", Before: unmanaged.Description, After: payload.Description}},
+				Payload:  payload,
+			}, ""
+		}
+		return &plannedChange{Action: actionCreate, Cluster: o.Cluster, Group: o.Group, Template: o.Template, Name: desired.Payload.Name, Payload: desired.Payload}, ""
+	}
+
+	changes := alertChanges(alert.Payload(), desired.Payload)
+	if len(changes) == 0 {
+		return nil, ""
+	}
+	return &plannedChange{Action: actionUpdate, Cluster: o.Cluster, Group: o.Group, Template: o.Template, AlertID: alert.AlertID, Name: alert.Name, Changes: changes, Payload: desired.Payload}, ""
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+const (
+	statusApplied    = "applied"
+	statusFailed     = "failed"
+	statusNotApplied = "not applied"
+)
+
+type changeResult struct {
+	Change plannedChange
+	Status string
+	Err    error
+}
+
+// syncReport records the outcome of every planned change, along with the clusters that could not be planned
+type syncReport struct {
+	Results []changeResult
+	Failed  []clusterFailure
+}
+
+// count returns the number of changes with the given status
+func (r syncReport) count(status string) int {
+	total := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			total++
+		}
+	}
+	return total
+}
+
+// clusterStatus summarises the outcome of a run for a single cluster, or for a group of clusters when alerts are not
+// grouped by cluster
+type clusterStatus struct {
+	Cluster string
+	Group   string
+	Applied int
+	Failed  int
+	Errors  []string
+}
+
+func (s clusterStatus) ok() bool {
+	return s.Failed == 0 && len(s.Errors) == 0
+}
+
+// name returns the cluster or group name, or "unmanaged alerts" for changes to alerts owned by neither
+func (s clusterStatus) name() string {
+	switch {
+	case s.Cluster != "":
+		return s.Cluster
+	case s.Group != "":
+		return s.Group
+	}
+	return "unmanaged alerts"
+}
+
+// describe returns the name for log messages
+func (s clusterStatus) describe() string {
+	if s.Cluster == "" && s.Group == "" {
+		return s.name()
+	}
+	return describeTarget(s.Cluster, s.Group)
+}
+
+// clusters returns the status of every cluster that had changes or failures, ordered by name.  Groups within a
+// cluster, such as namespaces, are summarised with their cluster.
+func (r syncReport) clusters() []clusterStatus {
+	byCluster := make(map[owner]*clusterStatus)
+	get := func(cluster string, group string) *clusterStatus {
+		key := owner{Cluster: cluster}
+		if cluster == "" {
+			key.Group = group
+		}
+		if _, ok := byCluster[key]; !ok {
+			byCluster[key] = &clusterStatus{Cluster: key.Cluster, Group: key.Group}
+		}
+		return byCluster[key]
+	}
+	for _, failure := range r.Failed {
+		status := get(failure.Cluster, failure.Group)
+		status.Errors = append(status.Errors, failure.Error)
+	}
+	for _, result := range r.Results {
+		status := get(result.Change.Cluster, result.Change.Group)
+		switch result.Status {
+		case statusApplied:
+			status.Applied++
+		case statusFailed:
+			status.Failed++
+			status.Errors = append(status.Errors, result.Err.Error())
+		}
+	}
+
+	statuses := make([]clusterStatus, 0, len(byCluster))
+	for _, status := range byCluster {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Cluster != statuses[j].Cluster {
+			return statuses[i].Cluster < statuses[j].Cluster
+		}
+		return statuses[i].Group < statuses[j].Group
+	})
+	return statuses
+}
+
+// failedClusters returns the names of the clusters with at least one failure, ordered by name
+func (r syncReport) failedClusters() []string {
+	var failed []string
+	for _, status := range r.clusters() {
+		if !status.ok() {
+			failed = append(failed, status.name())
+		}
+	}
+	return failed
+}
+
+// err returns an error listing the failed clusters, or nil when every cluster was synced
+func (r syncReport) err() error {
+	failed := r.failedClusters()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d cluster(s) failed to sync: %s", len(failed), strings.Join(failed, ", "))
+}
+
+// logSummary logs the per-cluster outcome of a run
+func logSummary(logger *logrus.Logger, report syncReport) {
+	statuses := report.clusters()
+	logger.Infof("Summary: %d change(s) applied, %d failed, %d cluster(s) could not be planned",
+		report.count(statusApplied), report.count(statusFailed), len(report.Failed))
+	for _, status := range statuses {
+		name := status.describe()
+		if status.ok() {
+			logger.Infof("  %s: ok (%d applied)", name, status.Applied)
+			continue
+		}
+		logger.Errorf("  %s: FAILED (%d applied, %d failed)", name, status.Applied, status.Failed)
+		for _, e := range status.Errors {
+			logger.Errorf("    %s", e)
+		}
+	}
+}
+
+// reportShutdown logs which planned changes were applied before an interrupted run stopped, and which were not
+func reportShutdown(logger *logrus.Logger, report syncReport) {
+	logger.Warnf("Interrupted, %d of %d planned changes were applied", report.count(statusApplied), len(report.Results))
+	for _, result := range report.Results {
+		logger.Warnf("  %s: %s alert '%s' for %s", result.Status, result.Change.Action, result.Change.Name, result.Change.describe())
+	}
+}
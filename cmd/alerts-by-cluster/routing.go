@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/selector"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/notification"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type channelRoute struct {
+	selectors  []selector.Selector
+	tags       map[string]selector.Selector
+	channelIDs []string
+}
+
+// matches reports whether a target matches one of the cluster selectors, when there are any, and every tag selector
+func (r channelRoute) matches(t target) bool {
+	if len(r.selectors) > 0 {
+		matched := false
+		for _, clusterSelector := range r.selectors {
+			if t.Cluster.Name != "" && clusterSelector.Match(t.Cluster.Name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	tags := t.tags()
+	for key, tagSelector := range r.tags {
+		if value, ok := tags[key]; !ok || !tagSelector.Match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// channelRouter maps targets to notification channel IDs using the routing rules in config
+type channelRouter struct {
+	routes []channelRoute
+}
+
+// newChannelRouter resolves the channel names referenced by the routing rules to IDs, failing when a name does not exist
+// or is shared by several channels
+func newChannelRouter(config *configuration.Config, channels *notification.ChannelQuery) (*channelRouter, error) {
+	channelIDs := make(map[string][]string, len(channels.NotificationChannels))
+	for _, channel := range channels.NotificationChannels {
+		channelIDs[channel.Name] = append(channelIDs[channel.Name], strconv.Itoa(channel.ID))
+	}
+
+	router := &channelRouter{}
+	for i, rule := range config.Routing {
+		selectors, err := selector.ParseAll(rule.Clusters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster selector in routing rule %d: %v", i, err)
+		}
+		route := channelRoute{selectors: selectors, tags: make(map[string]selector.Selector, len(rule.Tags))}
+		for key, pattern := range rule.Tags {
+			if route.tags[key], err = selector.Parse(pattern); err != nil {
+				return nil, fmt.Errorf("invalid selector for tag '%s' in routing rule %d: %v", key, i, err)
+			}
+		}
+		for _, channelName := range rule.Channels {
+			ids := channelIDs[channelName]
+			switch len(ids) {
+			case 0:
+				return nil, fmt.Errorf("notification channel '%s' referenced by routing rule %d does not exist", channelName, i)
+			case 1:
+				route.channelIDs = append(route.channelIDs, ids[0])
+			default:
+				return nil, fmt.Errorf("%d notification channels are named '%s', referenced by routing rule %d, rename all but one of the IDs %s", len(ids), channelName, i, strings.Join(ids, ", "))
+			}
+		}
+		router.routes = append(router.routes, route)
+	}
+	return router, nil
+}
+
+// channelsFor returns the channel IDs of every route matching the target.  A nil router routes nothing.
+func (r *channelRouter) channelsFor(t target) []string {
+	if r == nil {
+		return nil
+	}
+	var channelIDs []string
+	for _, route := range r.routes {
+		if route.matches(t) {
+			channelIDs = append(channelIDs, route.channelIDs...)
+		}
+	}
+	return channelIDs
+}
+
+// mergeChannelIDs returns the sorted union of two lists of channel IDs
+func mergeChannelIDs(a []string, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	merged := []string{}
+	for _, id := range append(append([]string{}, a...), b...) {
+		if !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
+	"github.com/sirupsen/logrus"
+)
+
+// metadataSource lists the clusters reporting to the backend, along with their agent tags when discovery.tags is set
+type metadataSource struct {
+	logger *logrus.Logger
+	config *configuration.Config
+	api    *secure.Client
+}
+
+func (s *metadataSource) Name() string {
+	return configuration.SourceMetadata
+}
+
+func (s *metadataSource) Clusters(ctx context.Context) ([]inventory.Cluster, error) {
+	rows, err := retrieveClusters(ctx, s.logger, s.config, s.api)
+	if err != nil {
+		return nil, err
+	}
+	tagged := make(map[string]inventory.Cluster)
+	if len(s.config.Discovery.Tags) > 0 {
+		clusters, err := retrieveClusterTags(ctx, s.logger, s.config, s.api)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range clusters {
+			tagged[c.Name] = c
+		}
+	}
+
+	var clusters []inventory.Cluster
+	for _, row := range rows.Data {
+		name := row.KubernetesClusterName()
+		if name == "" {
+			continue
+		}
+		c, ok := tagged[name]
+		if !ok {
+			c = inventory.NewCluster(name, s.config.Discovery.Tags)
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// newClusterSources returns the configured cluster sources in order of precedence, the metadata API alone when none
+// are configured
+func newClusterSources(logger *logrus.Logger, config *configuration.Config, api *secure.Client) []inventory.ClusterSource {
+	if len(config.Discovery.Sources) == 0 {
+		return []inventory.ClusterSource{&metadataSource{logger: logger, config: config, api: api}}
+	}
+
+	var sources []inventory.ClusterSource
+	for _, source := range config.Discovery.Sources {
+		switch source.Type {
+		case configuration.SourceMetadata:
+			sources = append(sources, &metadataSource{logger: logger, config: config, api: api})
+		case configuration.SourceStatic:
+			clusters := make([]inventory.Cluster, 0, len(source.Clusters))
+			for _, c := range source.Clusters {
+				clusters = append(clusters, inventory.Cluster{Name: c.Name, Tags: c.Tags})
+			}
+			sources = append(sources, inventory.NewStaticSource(clusters))
+		case configuration.SourceFile:
+			sources = append(sources, inventory.NewFileSource(source.Path))
+		case configuration.SourceKubeconfig:
+			sources = append(sources, inventory.NewKubeconfigSource(source.Path, source.NameFrom))
+		}
+	}
+	return sources
+}
+
+// groupsByCluster reports whether alerts are generated per cluster, the only grouping cluster sources can serve
+func groupsByCluster(config *configuration.Config) bool {
+	labels := groupBy(config)
+	return len(labels) == 1 && labels[0] == configuration.LabelClusterName
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/secure"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/selector"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// dimension is the value of one of the discovery group_by labels
+type dimension struct {
+	Key   string
+	Value string
+}
+
+// target is what a set of alerts is generated for: a cluster, a finer group such as a namespace of a cluster, or a
+// group of clusters such as an agent tag value, depending on discovery.group_by.  Dimensions holds every group_by
+// label in order.  The cluster name is empty when the grouping does not include it.
+type target struct {
+	Cluster    inventory.Cluster
+	Dimensions []dimension
+}
+
+// clusterTarget returns the target of a whole cluster, as grouped by default
+func clusterTarget(clusterName string) target {
+	return target{Cluster: inventory.Cluster{Name: clusterName}, Dimensions: []dimension{{Key: configuration.LabelClusterName, Value: clusterName}}}
+}
+
+// label returns the value of a grouping label, or an empty string when the target is not grouped by it
+func (t target) label(key string) string {
+	for _, d := range t.Dimensions {
+		if d.Key == key {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+// labels returns the grouping labels other than the cluster name
+func (t target) labels() map[string]string {
+	labels := make(map[string]string, len(t.Dimensions))
+	for _, d := range t.Dimensions {
+		if d.Key != configuration.LabelClusterName {
+			labels[d.Key] = d.Value
+		}
+	}
+	return labels
+}
+
+// tags returns the agent tags of the target's cluster, along with any agent tag the target is grouped by
+func (t target) tags() map[string]string {
+	tags := make(map[string]string, len(t.Cluster.Tags))
+	for key, value := range t.Cluster.Tags {
+		tags[key] = value
+	}
+	for _, d := range t.Dimensions {
+		if strings.HasPrefix(d.Key, inventory.TagLabelPrefix) {
+			tags[strings.TrimPrefix(d.Key, inventory.TagLabelPrefix)] = d.Value
+		}
+	}
+	return tags
+}
+
+// group identifies the target within its cluster as comma-separated key=value pairs, empty for a whole cluster
+func (t target) group() string {
+	var parts []string
+	for _, d := range t.Dimensions {
+		if d.Key != configuration.LabelClusterName {
+			parts = append(parts, fmt.Sprintf("%s=%s", d.Key, d.Value))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// scope returns the scope matching exactly the target, e.g.
+// kubernetes.cluster.name = "prod" and kubernetes.namespace.name = "payments"
+func (t target) scope() string {
+	dimensions := t.Dimensions
+	if len(dimensions) == 0 {
+		dimensions = clusterTarget(t.Cluster.Name).Dimensions
+	}
+	var conditions []string
+	for _, d := range dimensions {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", d.Key, scope.Quote(d.Value)))
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// describe returns a human-readable description of the target for logs and plans
+func (t target) describe() string {
+	return describeTarget(t.Cluster.Name, t.group())
+}
+
+func describeTarget(clusterName string, group string) string {
+	switch {
+	case group == "":
+		return fmt.Sprintf("cluster '%s'", clusterName)
+	case clusterName == "":
+		return fmt.Sprintf("group '%s'", group)
+	}
+	return fmt.Sprintf("cluster '%s' (%s)", clusterName, group)
+}
+
+// groupBy returns the labels alerts are generated for, the cluster name alone unless configured otherwise
+func groupBy(config *configuration.Config) []string {
+	if len(config.Discovery.GroupBy) == 0 {
+		return []string{configuration.LabelClusterName}
+	}
+	return config.Discovery.GroupBy
+}
+
+// targetsFromMetadata converts the discovered metadata rows into targets, ignoring rows missing any group_by label.
+// Each target gets the tags of its cluster from clusters, or empty tags when none were collected.
+func targetsFromMetadata(config *configuration.Config, result *metadata.ResultMetadata, clusters map[string]inventory.Cluster) []target {
+	var targets []target
+rows:
+	for _, row := range result.Data {
+		var t target
+		for _, key := range groupBy(config) {
+			if row[key] == "" {
+				continue rows
+			}
+			t.Dimensions = append(t.Dimensions, dimension{Key: key, Value: row[key]})
+		}
+		if clusterName := t.label(configuration.LabelClusterName); clusterName != "" {
+			t.Cluster = inventory.NewCluster(clusterName, config.Discovery.Tags)
+			if c, ok := clusters[clusterName]; ok {
+				t.Cluster = c
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// discoverTargets discovers the targets of an environment, along with the agent tags of their clusters when
+// discovery.tags is set.  When alerts are generated per cluster the clusters of every configured source are merged,
+// otherwise the metadata API is grouped by discovery.group_by.
+func discoverTargets(ctx context.Context, logger *logrus.Logger, config *configuration.Config, api *secure.Client) ([]target, error) {
+	if groupsByCluster(config) {
+		clusters, err := inventory.Merge(ctx, newClusterSources(logger, config, api)...)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve clusters: %w", err)
+		}
+		targets := make([]target, 0, len(clusters))
+		for _, c := range clusters {
+			// Clusters from inventories may lack tags that templates refer to
+			for _, key := range config.Discovery.Tags {
+				if _, ok := c.Tags[key]; !ok {
+					c.Tags[key] = ""
+				}
+			}
+			t := clusterTarget(c.Name)
+			t.Cluster = c
+			targets = append(targets, t)
+		}
+		return targets, nil
+	}
+
+	rows, err := retrieveClusters(ctx, logger, config, api)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve clusters: %w", err)
+	}
+	clusters := make(map[string]inventory.Cluster)
+	if len(config.Discovery.Tags) > 0 {
+		tagged, err := retrieveClusterTags(ctx, logger, config, api)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve cluster tags: %w", err)
+		}
+		for _, c := range tagged {
+			clusters[c.Name] = c
+		}
+	}
+	return targetsFromMetadata(config, rows, clusters), nil
+}
+
+// targetFilter applies the cluster selectors when grouping by cluster, and the namespace selectors when grouping by
+// namespace
+type targetFilter struct {
+	clusters   *selector.Filter
+	namespaces *selector.Filter
+}
+
+func newTargetFilter(config *configuration.Config) (*targetFilter, error) {
+	clusters, err := selector.NewFilter(config.Clusters.Include, config.Clusters.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := selector.NewFilter(config.Namespaces.Include, config.Namespaces.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &targetFilter{clusters: clusters, namespaces: namespaces}, nil
+}
+
+// Evaluate reports whether a target is selected, along with the reason when it is not
+func (f *targetFilter) Evaluate(t target) (bool, string) {
+	if t.Cluster.Name != "" {
+		if selected, reason := f.clusters.Evaluate(t.Cluster.Name); !selected {
+			return false, reason
+		}
+	}
+	if namespace := t.label(configuration.LabelNamespaceName); namespace != "" {
+		if selected, reason := f.namespaces.Evaluate(namespace); !selected {
+			return false, fmt.Sprintf("namespace %s", reason)
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"strings"
+	"text/template"
+)
+
+// clusterTemplateData holds the variables available to the name, description and scope templates.  Namespace, Labels
+// and Group are only set when discovery groups by other labels than the cluster, Scope always matches exactly the target.
+// Tags holds every configured agent tag key, empty when no agent of the cluster reports it.
+type clusterTemplateData struct {
+	ClusterName string
+	Tags        map[string]string
+	Namespace   string
+	Labels      map[string]string
+	Group       string
+	Scope       string
+	InstanceID  string
+}
+
+// desiredAlert is the rendered alert payload a template produces for a cluster
+type desiredAlert struct {
+	Owner   owner
+	Payload alerts.PayloadAlert
+}
+
+func renderTemplateField(field string, text string, data clusterTemplateData) (string, error) {
+	tmpl, err := template.New(field).Option("missingkey=error").Funcs(configuration.TemplateFuncs()).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// renderAlert renders an alert template for a target, prefixing the description with the ownership marker
+func renderAlert(config *configuration.Config, alertTemplate configuration.AlertTemplate, t target) (desiredAlert, error) {
+	var err error
+	data := clusterTemplateData{
+		ClusterName: t.Cluster.Name,
+		Tags:        t.tags(),
+		Namespace:   t.label(configuration.LabelNamespaceName),
+		Labels:      t.labels(),
+		Group:       t.group(),
+		Scope:       t.scope(),
+		InstanceID:  config.InstanceID,
+	}
+	o := owner{InstanceID: config.InstanceID, Template: alertTemplate.ID, Cluster: t.Cluster.Name, Group: t.group()}
+
+	payload := alerts.PayloadAlert{
+		Enabled:      alertTemplate.IsEnabled(),
+		Type:         alertTemplate.Type,
+		Repositories: append([]string{}, alertTemplate.Repositories...),
+		Triggers: alerts.PayloadTriggers{
+			Unscanned:      alertTemplate.Triggers.Unscanned,
+			AnalysisUpdate: alertTemplate.Triggers.AnalysisUpdate,
+			VulnUpdate:     alertTemplate.Triggers.VulnUpdate,
+			PolicyEval:     alertTemplate.Triggers.PolicyEval,
+		},
+		Autoscan:               alertTemplate.Autoscan,
+		OnlyPassFail:           alertTemplate.OnlyPassFail,
+		NotificationChannelIds: append([]string{}, alertTemplate.NotificationChannelIds...),
+	}
+	if payload.Name, err = renderTemplateField("name", alertTemplate.Name, data); err != nil {
+		return desiredAlert{}, err
+	}
+	if payload.Scope, err = renderTemplateField("scope", alertTemplate.Scope, data); err != nil {
+		return desiredAlert{}, err
+	}
+	// Catch cluster names that break out of their quotes before the backend does
+	if _, err = scope.Parse(payload.Scope); err != nil {
+		return desiredAlert{}, fmt.Errorf("%v, insert values with {{ quote .ClusterName }}", err)
+	}
+	var description string
+	if description, err = renderTemplateField("description", alertTemplate.Description, data); err != nil {
+		return desiredAlert{}, err
+	}
+	payload.Description = withOwnerMarker(ownerMarker(o), description)
+
+	return desiredAlert{Owner: o, Payload: payload}, nil
+}
+
+// desiredAlertsForTarget renders every configured template for a target and adds the channels routed to it
+func desiredAlertsForTarget(config *configuration.Config, router *channelRouter, t target) ([]desiredAlert, error) {
+	var desired []desiredAlert
+	for _, alertTemplate := range config.Templates {
+		rendered, err := renderAlert(config, alertTemplate, t)
+		if err != nil {
+			return nil, fmt.Errorf("could not render template '%s' for %s: %v", alertTemplate.ID, t.describe(), err)
+		}
+		rendered.Payload.NotificationChannelIds = mergeChannelIDs(rendered.Payload.NotificationChannelIds, router.channelsFor(t))
+		desired = append(desired, rendered)
+	}
+	return desired, nil
+}
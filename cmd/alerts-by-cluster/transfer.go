@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/config"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+// The export file uses the same format as the alerts API, {"alerts": [...]}, so that API responses can be imported
+// as they are.
+
+func (c *cli) newExportCommand() *cobra.Command {
+	var file string
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the managed scanning alerts as JSON",
+		Args:  cobra.NoArgs,
+		Annotations: map[string]string{
+			annotationDataOutput: "true",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, api, err := c.singleEnvironment()
+			if err != nil {
+				return err
+			}
+			existing, err := api.Alerts.List(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("could not retrieve alerts: %w", err)
+			}
+
+			exported := alerts.AlertQuery{Alerts: []alerts.Alert{}}
+			for _, alert := range existing.Alerts {
+				if _, managed := managedOwner(alert, env.Config.InstanceID); managed || all {
+					exported.Alerts = append(exported.Alerts, alert)
+				}
+			}
+
+			w := cmd.OutOrStdout()
+			if file != "-" {
+				f, err := os.Create(file)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					_ = f.Close()
+				}()
+				w = f
+			}
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			if err = encoder.Encode(exported); err != nil {
+				return err
+			}
+			c.logger.Infof("Exported %d alert(s)", len(exported.Alerts))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "-", "File to write the alerts to, - for stdout")
+	cmd.Flags().BoolVar(&all, "all", false, "Also export the alerts that are not managed by this instance")
+	return cmd
+}
+
+// readExport reads an export file, or stdin when path is -
+func readExport(stdin io.Reader, path string) (*alerts.AlertQuery, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+	imported := &alerts.AlertQuery{}
+	if err := json.NewDecoder(r).Decode(imported); err != nil {
+		return nil, fmt.Errorf("could not decode '%s': %v", path, err)
+	}
+	return imported, nil
+}
+
+// planImport returns the changes needed to make the backend hold the imported alerts.  Alerts carrying an ownership
+// marker are matched by owner, others by name.  Matching alerts are updated when they differ, the rest are created.
+// An unmanaged alert whose name several existing alerts share fails the import, creating it would add yet another.
+func planImport(existing *alerts.AlertQuery, imported *alerts.AlertQuery) (syncPlan, error) {
+	plan := syncPlan{Changes: []plannedChange{}}
+	for _, alert := range imported.Alerts {
+		payload := alert.Payload()
+		o, managed := parseOwnerMarker(alert.Description)
+
+		var current *alerts.Alert
+		if managed {
+			current = findAlert(existing, o)
+		} else {
+			var err error
+			if current, err = findAlertByName(existing, alert.Name); err != nil {
+				return syncPlan{}, fmt.Errorf("could not import alert '%s': %w", alert.Name, err)
+			}
+		}
+
+		if current == nil {
+			plan.Changes = append(plan.Changes, plannedChange{Action: actionCreate, Cluster: o.Cluster, Group: o.Group, Template: o.Template, Name: alert.Name, Payload: payload})
+			continue
+		}
+		if changes := alertChanges(current.Payload(), payload); len(changes) > 0 {
+			plan.Changes = append(plan.Changes, plannedChange{Action: actionUpdate, Cluster: o.Cluster, Group: o.Group, Template: o.Template, AlertID: current.AlertID, Name: current.Name, Changes: changes, Payload: payload})
+		}
+	}
+	return plan, nil
+}
+
+func (c *cli) newImportCommand() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Create or update scanning alerts from an export file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imported, err := readExport(cmd.InOrStdin(), file)
+			if err != nil {
+				return err
+			}
+			env, api, err := c.singleEnvironment()
+			if err != nil {
+				return err
+			}
+			existing, err := api.Alerts.List(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("could not retrieve alerts: %w", err)
+			}
+
+			plan, err := planImport(existing, imported)
+			if err != nil {
+				return err
+			}
+			if env.Config.DryRun {
+				return printPlan(cmd.OutOrStdout(), plan, env.Config.Output)
+			}
+			report, err := applyPlan(cmd.Context(), c.logger, env.Config, plan, api)
+			if err != nil {
+				reportShutdown(c.logger, report)
+				return err
+			}
+			logSummary(c.logger, report)
+			return report.err()
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "-", "Export file to read the alerts from, - for stdin")
+	configuration.AddFlags(cmd.Flags(), "dry-run", "workers", "operation_timeout")
+	return cmd
+}
@@ -2,10 +2,15 @@ package configuration
 
 import (
 	"errors"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
 type ConfigManager struct {
@@ -26,6 +31,39 @@ func (cm *ConfigManager) LoadConfig() error {
 	viper.AddConfigPath(".")      // optionally look for config in the working directory
 	viper.AutomaticEnv()          // read in environment variables that match
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetDefault("secure_url", "")
+	viper.SetDefault("secure_api_token", "")
+	viper.SetDefault("prune.enabled", false)
+	viper.SetDefault("prune.action", PruneActionDisable)
+	viper.SetDefault("prune.allow_empty", false)
+	viper.SetDefault("instance_id", "default")
+	viper.SetDefault("adopt", false)
+	viper.SetDefault("discovery.page_size", 1000)
+	viper.SetDefault("discovery.lookback", "")
+	viper.SetDefault("discovery.group_by", []string{LabelClusterName})
+	viper.SetDefault("discovery.filter", "")
+	viper.SetDefault("discovery.tags", []string{})
+	viper.SetDefault("discovery.sources", []Source{})
+	viper.SetDefault("operation_timeout", 0)
+	viper.SetDefault("workers", 4)
+	viper.SetDefault("parallel_environments", false)
+	viper.SetDefault("selected_environment", "")
+	viper.SetDefault("http.max_idle_conns", 100)
+	viper.SetDefault("http.max_idle_conns_per_host", 10)
+	viper.SetDefault("http.idle_conn_timeout", "90s")
+	viper.SetDefault("http.http2", true)
+	viper.SetDefault("http.https_proxy", "")
+	viper.SetDefault("http.no_proxy", "")
+	viper.SetDefault("http.user_agent", "")
+	viper.SetDefault("http.rate_limit", 10)
+	viper.SetDefault("http.rate_burst", 10)
+	viper.SetDefault("tls.ca_bundle", "")
+	viper.SetDefault("tls.client_cert", "")
+	viper.SetDefault("tls.client_key", "")
+	viper.SetDefault("tls.min_version", "1.2")
+	viper.SetDefault("tls.insecure_skip_verify", false)
+	viper.SetDefault("dry_run", false)
+	viper.SetDefault("output", OutputText)
 
 	if err := viper.ReadInConfig(); err != nil {
 		cm.log.Printf("Config file (%s) not found, continuing without", viper.ConfigFileUsed())
@@ -33,21 +71,16 @@ func (cm *ConfigManager) LoadConfig() error {
 		cm.log.Println("Using config file:", viper.ConfigFileUsed())
 	}
 
-	// Define command-line flags
-	pflag.String("secure_url", "", "Secure URL for the application")
-	pflag.String("secure_api_token", "", "Secure API token for the application")
-	pflag.Parse()
-
-	// Bind command-line flags to Viper
-	viper.BindPFlag("secure_url", pflag.Lookup("secure_url"))
-	viper.BindPFlag("secure_api_token", pflag.Lookup("secure_api_token"))
-
 	// Unmarshal the config into the Config struct
 	err := viper.Unmarshal(cm.config)
 	if err != nil {
 		return err
 	}
 
+	if len(cm.config.Templates) == 0 {
+		cm.config.Templates = []AlertTemplate{DefaultAlertTemplate()}
+	}
+
 	return nil
 }
 
@@ -55,11 +88,43 @@ func (cm *ConfigManager) ValidateConfig() error {
 	if cm.config == nil {
 		return errors.New("config is nil")
 	}
-	if cm.config.SecureURL == "" {
-		return errors.New("missing SECURE_URL")
+	if err := validateEnvironments(cm.config); err != nil {
+		return err
+	}
+	if cm.config.InstanceID == "" {
+		return errors.New("missing INSTANCE_ID")
 	}
-	if cm.config.SecureAPIToken == "" {
-		return errors.New("missing SECURE_API_TOKEN")
+	if cm.config.Prune.Action != PruneActionDisable && cm.config.Prune.Action != PruneActionDelete {
+		return fmt.Errorf("invalid prune action '%s', expected '%s' or '%s'", cm.config.Prune.Action, PruneActionDisable, PruneActionDelete)
+	}
+	if cm.config.Discovery.PageSize <= 0 {
+		return fmt.Errorf("invalid discovery page size %d, expected a positive number", cm.config.Discovery.PageSize)
+	}
+	if _, err := ParseLookback(cm.config.Discovery.Lookback); err != nil {
+		return err
+	}
+	if err := validateGroupBy(cm.config.Discovery.GroupBy); err != nil {
+		return err
+	}
+	if err := validateTagKeys(cm.config.Discovery.Tags); err != nil {
+		return err
+	}
+	if err := validateSources(cm.config.Discovery); err != nil {
+		return err
+	}
+	if cm.config.Discovery.Filter != "" {
+		if _, err := scope.Parse(cm.config.Discovery.Filter); err != nil {
+			return fmt.Errorf("invalid discovery filter: %v", err)
+		}
+	}
+	if cm.config.Workers <= 0 {
+		return fmt.Errorf("invalid number of workers %d, expected a positive number", cm.config.Workers)
+	}
+	if cm.config.HTTP.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit %g, expected zero (unlimited) or a positive number", cm.config.HTTP.RateLimit)
+	}
+	if cm.config.Output != OutputText && cm.config.Output != OutputJSON {
+		return fmt.Errorf("invalid output format '%s', expected '%s' or '%s'", cm.config.Output, OutputText, OutputJSON)
 	}
 	return nil
 }
@@ -67,3 +132,116 @@ func (cm *ConfigManager) ValidateConfig() error {
 func (cm *ConfigManager) GetConfig() *Config {
 	return cm.config
 }
+
+// validateGroupBy checks that the discovery grouping lists at least one label and repeats none
+func validateGroupBy(groupBy []string) error {
+	if len(groupBy) == 0 {
+		return fmt.Errorf("invalid discovery group_by, expected at least one label such as %s", LabelClusterName)
+	}
+	seen := make(map[string]bool, len(groupBy))
+	for _, label := range groupBy {
+		if label == "" || seen[label] {
+			return fmt.Errorf("invalid discovery group_by %v, labels must be non-empty and unique", groupBy)
+		}
+		seen[label] = true
+	}
+	return nil
+}
+
+// validateTagKeys checks that the agent tag keys collected during discovery are unique and can be used in labels
+func validateTagKeys(tagKeys []string) error {
+	seen := make(map[string]bool, len(tagKeys))
+	for _, key := range tagKeys {
+		if key == "" || strings.ContainsAny(key, " \t=,") {
+			return fmt.Errorf("invalid discovery tag key '%s'", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate discovery tag key '%s'", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// validateSources checks that every cluster source is complete.  Sources other than the metadata API only know cluster
+// names, so they require alerts to be grouped by cluster alone and cannot be restricted by discovery.filter.
+func validateSources(discovery DiscoveryConfig) error {
+	for i, source := range discovery.Sources {
+		switch source.Type {
+		case SourceMetadata:
+			continue
+		case SourceStatic:
+			if len(source.Clusters) == 0 {
+				return fmt.Errorf("static cluster source %d lists no clusters", i)
+			}
+			for j, cluster := range source.Clusters {
+				if cluster.Name == "" {
+					return fmt.Errorf("cluster %d of static cluster source %d is missing a name", j, i)
+				}
+			}
+		case SourceFile, SourceKubeconfig:
+			if source.Path == "" {
+				return fmt.Errorf("%s cluster source %d requires a path", source.Type, i)
+			}
+			if source.Type == SourceKubeconfig && source.NameFrom != "" && source.NameFrom != inventory.KubeconfigNameFromCluster && source.NameFrom != inventory.KubeconfigNameFromContext {
+				return fmt.Errorf("invalid name_from '%s' in kubeconfig cluster source %d, expected '%s' or '%s'", source.NameFrom, i, inventory.KubeconfigNameFromCluster, inventory.KubeconfigNameFromContext)
+			}
+		default:
+			return fmt.Errorf("invalid type '%s' for cluster source %d, expected one of %s, %s, %s or %s", source.Type, i, SourceMetadata, SourceStatic, SourceFile, SourceKubeconfig)
+		}
+		if len(discovery.GroupBy) != 1 || discovery.GroupBy[0] != LabelClusterName {
+			return fmt.Errorf("%s cluster source %d only lists clusters, discovery group_by must be %s alone", source.Type, i, LabelClusterName)
+		}
+		if discovery.Filter != "" {
+			return fmt.Errorf("%s cluster source %d cannot apply discovery.filter, which only restricts the metadata source", source.Type, i)
+		}
+	}
+	return nil
+}
+
+func validateTemplates(templates []AlertTemplate) error {
+	seen := make(map[string]bool, len(templates))
+	for i, tmpl := range templates {
+		if tmpl.ID == "" {
+			return fmt.Errorf("template %d is missing an id", i)
+		}
+		if seen[tmpl.ID] {
+			return fmt.Errorf("duplicate template id '%s'", tmpl.ID)
+		}
+		seen[tmpl.ID] = true
+		if tmpl.Name == "" || tmpl.Scope == "" {
+			return fmt.Errorf("template '%s' requires a name and a scope", tmpl.ID)
+		}
+		for field, text := range map[string]string{"name": tmpl.Name, "description": tmpl.Description, "scope": tmpl.Scope} {
+			if _, err := template.New(field).Option("missingkey=error").Funcs(TemplateFuncs()).Parse(text); err != nil {
+				return fmt.Errorf("template '%s' has an invalid %s: %v", tmpl.ID, field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseLookback parses a discovery lookback such as "7d", "12h" or "90m".  Days are accepted in addition to the
+// units understood by time.ParseDuration.  An empty lookback returns zero, meaning the backend's default window.
+func ParseLookback(lookback string) (time.Duration, error) {
+	if lookback == "" {
+		return 0, nil
+	}
+	var duration time.Duration
+	if strings.HasSuffix(lookback, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(lookback, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid discovery lookback '%s': %v", lookback, err)
+		}
+		duration = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if duration, err = time.ParseDuration(lookback); err != nil {
+			return 0, fmt.Errorf("invalid discovery lookback '%s': %v", lookback, err)
+		}
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid discovery lookback '%s', expected a positive duration", lookback)
+	}
+	return duration, nil
+}
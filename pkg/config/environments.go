@@ -0,0 +1,160 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/selector"
+	"os"
+)
+
+// EnvironmentConfig is the effective configuration used to sync a single environment
+type EnvironmentConfig struct {
+	Name   string
+	Config *Config
+}
+
+// ResolveEnvironments returns the effective configuration of every environment, in the order they are configured,
+// or only of the environment selected with --environment.  Without any environments the top-level settings are
+// returned as a single unnamed environment.
+func (c *Config) ResolveEnvironments() []EnvironmentConfig {
+	if len(c.Environments) == 0 {
+		return []EnvironmentConfig{{Config: c}}
+	}
+
+	resolved := make([]EnvironmentConfig, 0, len(c.Environments))
+	for _, env := range c.Environments {
+		if c.Environment != "" && env.Name != c.Environment {
+			continue
+		}
+		envConfig := *c
+		envConfig.Environments = nil
+		envConfig.Environment = ""
+		if env.SecureURL != "" {
+			envConfig.SecureURL = env.SecureURL
+		}
+		if env.SecureAPITokenEnv != "" {
+			envConfig.SecureAPIToken = os.Getenv(env.SecureAPITokenEnv)
+		}
+		if env.SecureAPIToken != "" {
+			envConfig.SecureAPIToken = env.SecureAPIToken
+		}
+		if len(env.Templates) > 0 {
+			envConfig.Templates = env.Templates
+		}
+		if env.Clusters != nil {
+			envConfig.Clusters = *env.Clusters
+		}
+		if env.Namespaces != nil {
+			envConfig.Namespaces = *env.Namespaces
+		}
+		if len(env.Routing) > 0 {
+			envConfig.Routing = env.Routing
+		}
+		resolved = append(resolved, EnvironmentConfig{Name: env.Name, Config: &envConfig})
+	}
+	return resolved
+}
+
+// validateEnvironments checks that every environment is uniquely named, then validates its effective configuration
+func validateEnvironments(c *Config) error {
+	seen := make(map[string]bool, len(c.Environments))
+	for i, env := range c.Environments {
+		if env.Name == "" {
+			return fmt.Errorf("environment %d is missing a name", i)
+		}
+		if seen[env.Name] {
+			return fmt.Errorf("duplicate environment name '%s'", env.Name)
+		}
+		seen[env.Name] = true
+		if env.SecureAPITokenEnv != "" && env.SecureAPIToken == "" && os.Getenv(env.SecureAPITokenEnv) == "" {
+			return fmt.Errorf("environment '%s': environment variable %s holding the API token is not set", env.Name, env.SecureAPITokenEnv)
+		}
+	}
+
+	if c.Environment != "" && !seen[c.Environment] {
+		return fmt.Errorf("unknown environment '%s'", c.Environment)
+	}
+
+	for _, env := range c.ResolveEnvironments() {
+		if err := validateEnvironment(env.Config); err != nil {
+			if env.Name == "" {
+				return err
+			}
+			return fmt.Errorf("environment '%s': %w", env.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateEnvironment checks the settings that can differ between the backends being synced
+func validateEnvironment(c *Config) error {
+	if c.SecureURL == "" {
+		return errors.New("missing SECURE_URL")
+	}
+	if c.SecureAPIToken == "" {
+		return errors.New("missing SECURE_API_TOKEN")
+	}
+	if err := validateTemplates(c.Templates); err != nil {
+		return err
+	}
+	if _, err := selector.NewFilter(c.Clusters.Include, c.Clusters.Exclude); err != nil {
+		return fmt.Errorf("invalid cluster selector: %v", err)
+	}
+	// Selectors only apply to targets carrying their label, so they would otherwise be silently ignored
+	if (len(c.Clusters.Include) > 0 || len(c.Clusters.Exclude) > 0) && !groupsBy(c, LabelClusterName) {
+		return fmt.Errorf("cluster selectors require discovery.group_by to include %s", LabelClusterName)
+	}
+	if _, err := selector.NewFilter(c.Namespaces.Include, c.Namespaces.Exclude); err != nil {
+		return fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	if (len(c.Namespaces.Include) > 0 || len(c.Namespaces.Exclude) > 0) && !groupsBy(c, LabelNamespaceName) {
+		return fmt.Errorf("namespace selectors require discovery.group_by to include %s", LabelNamespaceName)
+	}
+	for i, route := range c.Routing {
+		if (len(route.Clusters) == 0 && len(route.Tags) == 0) || len(route.Channels) == 0 {
+			return fmt.Errorf("routing rule %d requires at least one cluster or tag selector and one channel", i)
+		}
+		if _, err := selector.ParseAll(route.Clusters); err != nil {
+			return fmt.Errorf("invalid cluster selector in routing rule %d: %v", i, err)
+		}
+		for key, pattern := range route.Tags {
+			if !collectsTag(c, key) {
+				return fmt.Errorf("routing rule %d matches tag '%s', which is not collected, add it to discovery.tags", i, key)
+			}
+			if _, err := selector.Parse(pattern); err != nil {
+				return fmt.Errorf("invalid selector for tag '%s' in routing rule %d: %v", key, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// groupsBy reports whether alerts are generated per value of the label, an empty group_by meaning per cluster
+func groupsBy(c *Config, label string) bool {
+	if len(c.Discovery.GroupBy) == 0 {
+		return label == LabelClusterName
+	}
+	for _, groupLabel := range c.Discovery.GroupBy {
+		if groupLabel == label {
+			return true
+		}
+	}
+	return false
+}
+
+// collectsTag reports whether the agent tag key is known for every target, either collected for each cluster or used to
+// group the alerts
+func collectsTag(c *Config, key string) bool {
+	for _, tagKey := range c.Discovery.Tags {
+		if tagKey == key {
+			return true
+		}
+	}
+	for _, label := range c.Discovery.GroupBy {
+		if label == inventory.TagLabel(key) {
+			return true
+		}
+	}
+	return false
+}
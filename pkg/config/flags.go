@@ -0,0 +1,127 @@
+package configuration
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// flagDefinition describes a command-line flag and the configuration key it overrides
+type flagDefinition struct {
+	key    string
+	define func(flags *pflag.FlagSet, name string)
+}
+
+// flagDefinitions holds every flag understood by the configuration, by flag name.  Commands pick the ones they
+// support with AddFlags.
+var flagDefinitions = map[string]flagDefinition{
+	"secure_url": {"secure_url", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Secure URL for the application")
+	}},
+	"secure_api_token": {"secure_api_token", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Secure API token for the application")
+	}},
+	"environment": {"selected_environment", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Only use the named environment from the environments list")
+	}},
+	"prune": {"prune.enabled", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Prune managed alerts for clusters that no longer exist")
+	}},
+	"prune_action": {"prune.action", func(f *pflag.FlagSet, n string) {
+		f.String(n, PruneActionDisable, "Action to take on pruned alerts (disable|delete)")
+	}},
+	"prune_allow_empty": {"prune.allow_empty", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Prune managed alerts even when discovery finds no clusters at all")
+	}},
+	"instance_id": {"instance_id", func(f *pflag.FlagSet, n string) {
+		f.String(n, "default", "Identifier written into the ownership marker of managed alerts")
+	}},
+	"adopt": {"adopt", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Mark pre-existing alerts matching a cluster scope as managed")
+	}},
+	"include": {"clusters.include", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, nil, "Only manage clusters matching these names, globs or regex: patterns")
+	}},
+	"exclude": {"clusters.exclude", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, nil, "Never manage clusters matching these names, globs or regex: patterns")
+	}},
+	"include_namespaces": {"namespaces.include", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, nil, "Only manage namespaces matching these names, globs or regex: patterns")
+	}},
+	"exclude_namespaces": {"namespaces.exclude", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, nil, "Never manage namespaces matching these names, globs or regex: patterns")
+	}},
+	"group_by": {"discovery.group_by", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, []string{LabelClusterName}, "Labels alerts are generated for, e.g. kubernetes.cluster.name,kubernetes.namespace.name")
+	}},
+	"filter": {"discovery.filter", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Only discover entities matching this scope expression, e.g. 'agent.tag.env = \"prod\"'")
+	}},
+	"tags": {"discovery.tags", func(f *pflag.FlagSet, n string) {
+		f.StringSlice(n, nil, "Agent tag keys collected for every cluster, e.g. env,team,region")
+	}},
+	"page_size": {"discovery.page_size", func(f *pflag.FlagSet, n string) {
+		f.Int(n, 1000, "Number of metadata rows requested per page during cluster discovery")
+	}},
+	"lookback": {"discovery.lookback", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Discovery time window, e.g. 7d or 12h (defaults to the backend's window)")
+	}},
+	"operation_timeout": {"operation_timeout", func(f *pflag.FlagSet, n string) {
+		f.Duration(n, 0, "Deadline for each create, update or delete, e.g. 30s (0 disables)")
+	}},
+	"workers": {"workers", func(f *pflag.FlagSet, n string) {
+		f.Int(n, 4, "Number of create, update or delete operations run concurrently")
+	}},
+	"parallel_environments": {"parallel_environments", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Sync the configured environments concurrently")
+	}},
+	"rate_limit": {"http.rate_limit", func(f *pflag.FlagSet, n string) {
+		f.Float64(n, 10, "Maximum requests per second sent to the backend (0 disables)")
+	}},
+	"ca_bundle": {"tls.ca_bundle", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "PEM bundle of CA certificates trusted in addition to the system roots")
+	}},
+	"insecure_skip_verify": {"tls.insecure_skip_verify", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Disable TLS certificate verification (not recommended)")
+	}},
+	"https_proxy": {"http.https_proxy", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Proxy for requests to the backend (defaults to the HTTPS_PROXY environment variable)")
+	}},
+	"no_proxy": {"http.no_proxy", func(f *pflag.FlagSet, n string) {
+		f.String(n, "", "Comma separated hosts that bypass the proxy")
+	}},
+	"dry-run": {"dry_run", func(f *pflag.FlagSet, n string) {
+		f.Bool(n, false, "Print the planned changes without applying them")
+	}},
+	"output": {"output", func(f *pflag.FlagSet, n string) {
+		f.String(n, OutputText, "Output format (text|json)")
+	}},
+}
+
+// AddFlags defines the named configuration flags on a command's flag set.  It panics on an unknown name, which is
+// a programming error.
+func AddFlags(flags *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		definition, ok := flagDefinitions[name]
+		if !ok {
+			panic(fmt.Sprintf("unknown configuration flag '%s'", name))
+		}
+		definition.define(flags, name)
+	}
+}
+
+// BindFlags binds the configuration flags present in a parsed flag set to their configuration keys, so that flags
+// take precedence over the environment and the config file on the next LoadConfig.  Only the flags of the command
+// being run should be bound, flags of other commands would otherwise shadow the config file with their defaults.
+func (cm *ConfigManager) BindFlags(flags *pflag.FlagSet) error {
+	for name, definition := range flagDefinitions {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			continue
+		}
+		if err := viper.BindPFlag(definition.key, flag); err != nil {
+			return fmt.Errorf("could not bind flag '%s': %v", name, err)
+		}
+	}
+	return nil
+}
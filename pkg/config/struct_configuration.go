@@ -1,6 +1,133 @@
 package configuration
 
+import (
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/inventory"
+	"time"
+)
+
 type Config struct {
-	SecureURL      string `mapstructure:"secure_url"`
-	SecureAPIToken string `mapstructure:"secure_api_token"`
+	SecureURL        string              `mapstructure:"secure_url"`
+	SecureAPIToken   string              `mapstructure:"secure_api_token"`
+	Prune            PruneConfig         `mapstructure:"prune"`
+	InstanceID       string              `mapstructure:"instance_id"`
+	Adopt            bool                `mapstructure:"adopt"`
+	DryRun           bool                `mapstructure:"dry_run"`
+	Output           string              `mapstructure:"output"`
+	Templates        []AlertTemplate     `mapstructure:"templates"`
+	Clusters         ClusterSelectors    `mapstructure:"clusters"`
+	Namespaces       ClusterSelectors    `mapstructure:"namespaces"`
+	Routing          []NotificationRoute `mapstructure:"routing"`
+	Discovery        DiscoveryConfig     `mapstructure:"discovery"`
+	OperationTimeout time.Duration       `mapstructure:"operation_timeout"`
+	Workers          int                 `mapstructure:"workers"`
+	HTTP             HTTPConfig          `mapstructure:"http"`
+	TLS              TLSConfig           `mapstructure:"tls"`
+	Environments     []Environment       `mapstructure:"environments"`
+	Parallel         bool                `mapstructure:"parallel_environments"`
+	Environment      string              `mapstructure:"selected_environment"`
+}
+
+// TLSConfig controls verification of the backend certificate and the optional client certificate for mutual TLS
+type TLSConfig struct {
+	CABundle           string `mapstructure:"ca_bundle"`
+	ClientCert         string `mapstructure:"client_cert"`
+	ClientKey          string `mapstructure:"client_key"`
+	MinVersion         string `mapstructure:"min_version"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// HTTPConfig tunes the pooled transport shared by every request to the backend.  An empty UserAgent identifies the
+// tool and its version.
+type HTTPConfig struct {
+	MaxIdleConns        int               `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int               `mapstructure:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration     `mapstructure:"idle_conn_timeout"`
+	HTTP2               bool              `mapstructure:"http2"`
+	HTTPSProxy          string            `mapstructure:"https_proxy"`
+	NoProxy             string            `mapstructure:"no_proxy"`
+	Headers             map[string]string `mapstructure:"headers"`
+	UserAgent           string            `mapstructure:"user_agent"`
+	RateLimit           float64           `mapstructure:"rate_limit"`
+	RateBurst           int               `mapstructure:"rate_burst"`
+}
+
+type DiscoveryConfig struct {
+	PageSize int      `mapstructure:"page_size"`
+	Lookback string   `mapstructure:"lookback"`
+	GroupBy  []string `mapstructure:"group_by"`
+	Filter   string   `mapstructure:"filter"`
+	Tags     []string `mapstructure:"tags"`
+	Sources  []Source `mapstructure:"sources"`
+}
+
+// Source is one of the sources clusters are discovered from, listed in order of precedence.  The metadata API is the
+// only source when none are configured.
+type Source struct {
+	Type     string          `mapstructure:"type"`
+	Path     string          `mapstructure:"path"`
+	NameFrom string          `mapstructure:"name_from"`
+	Clusters []StaticCluster `mapstructure:"clusters"`
 }
+
+// StaticCluster is a cluster listed in the configuration by a static source
+type StaticCluster struct {
+	Name string            `mapstructure:"name"`
+	Tags map[string]string `mapstructure:"tags"`
+}
+
+// Environment is a Sysdig backend synced in the same run as the others.  Templates, cluster selectors and routing
+// fall back to the top-level settings when they are not set on the environment.
+type Environment struct {
+	Name              string              `mapstructure:"name"`
+	SecureURL         string              `mapstructure:"secure_url"`
+	SecureAPIToken    string              `mapstructure:"secure_api_token"`
+	SecureAPITokenEnv string              `mapstructure:"secure_api_token_env"`
+	Templates         []AlertTemplate     `mapstructure:"templates"`
+	Clusters          *ClusterSelectors   `mapstructure:"clusters"`
+	Namespaces        *ClusterSelectors   `mapstructure:"namespaces"`
+	Routing           []NotificationRoute `mapstructure:"routing"`
+}
+
+// NotificationRoute sends the alerts of every cluster matching one of the selectors, and every one of the tag
+// selectors, to the named notification channels.  Tags maps agent tag keys, such as env, to a name, glob or regex.
+type NotificationRoute struct {
+	Clusters []string          `mapstructure:"clusters"`
+	Tags     map[string]string `mapstructure:"tags"`
+	Channels []string          `mapstructure:"channels"`
+}
+
+// ClusterSelectors lists exact names, globs or "regex:" prefixed regular expressions matched against cluster names
+type ClusterSelectors struct {
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+type PruneConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	Action     string `mapstructure:"action"`
+	AllowEmpty bool   `mapstructure:"allow_empty"`
+}
+
+const (
+	PruneActionDisable = "disable"
+	PruneActionDelete  = "delete"
+)
+
+const (
+	SourceMetadata   = "metadata"
+	SourceStatic     = "static"
+	SourceFile       = "file"
+	SourceKubeconfig = "kubeconfig"
+)
+
+// Well-known labels alerts can be grouped by during discovery.  Any metadata label, such as agent.tag.env, can be
+// used.
+const (
+	LabelClusterName   = inventory.LabelClusterName
+	LabelNamespaceName = "kubernetes.namespace.name"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
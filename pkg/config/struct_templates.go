@@ -0,0 +1,62 @@
+package configuration
+
+import (
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/scope"
+	"text/template"
+)
+
+// AlertTemplate describes the alerts.PayloadAlert created for every cluster.  Name, Description and Scope are
+// Go text/template strings rendered with the cluster variables, e.g. "Cluster: {{ .ClusterName }}".  Values
+// inserted into a scope should go through the quote function, e.g. kubernetes.cluster.name = {{ quote .ClusterName }},
+// so that names containing quotes cannot break the expression.  When alerts are grouped by other labels,
+// {{ .Namespace }}, {{ .Labels }} and {{ .Group }} hold their values, and {{ .Scope }} the scope matching all of them.
+type AlertTemplate struct {
+	ID                     string           `mapstructure:"id"`
+	Enabled                *bool            `mapstructure:"enabled"`
+	Type                   string           `mapstructure:"type"`
+	Name                   string           `mapstructure:"name"`
+	Description            string           `mapstructure:"description"`
+	Scope                  string           `mapstructure:"scope"`
+	Repositories           []string         `mapstructure:"repositories"`
+	Triggers               TemplateTriggers `mapstructure:"triggers"`
+	Autoscan               bool             `mapstructure:"autoscan"`
+	OnlyPassFail           bool             `mapstructure:"only_pass_fail"`
+	NotificationChannelIds []string         `mapstructure:"notification_channel_ids"`
+}
+
+type TemplateTriggers struct {
+	Unscanned      bool `mapstructure:"unscanned"`
+	AnalysisUpdate bool `mapstructure:"analysis_update"`
+	VulnUpdate     bool `mapstructure:"vuln_update"`
+	PolicyEval     bool `mapstructure:"policy_eval"`
+}
+
+const DefaultTemplateID = "default"
+
+// DefaultAlertTemplate returns the template used when none are configured.  When grouping by cluster only it renders
+// the alerts created by earlier versions.
+func DefaultAlertTemplate() AlertTemplate {
+	return AlertTemplate{
+		ID:    DefaultTemplateID,
+		Type:  "runtime",
+		Name:  "{{ if .ClusterName }}Cluster: {{ .ClusterName }}{{ if .Namespace }} / Namespace: {{ .Namespace }}{{ end }}{{ else }}Group: {{ .Group }}{{ end }}",
+		Scope: "{{ .Scope }}",
+		Triggers: TemplateTriggers{
+			Unscanned:  true,
+			VulnUpdate: true,
+			PolicyEval: true,
+		},
+	}
+}
+
+// TemplateFuncs returns the functions available to alert templates
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"quote": scope.Quote,
+	}
+}
+
+// IsEnabled reports whether alerts rendered from the template should be enabled, defaulting to true when unset
+func (t AlertTemplate) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
@@ -0,0 +1,120 @@
+// Package inventory models the kubernetes clusters alerts are generated for, along with the agent tags, such as env,
+// team or region, collected for each of them.
+package inventory
+
+import (
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"sort"
+	"strings"
+)
+
+// LabelClusterName is the metadata label holding the kubernetes cluster name
+const LabelClusterName = "kubernetes.cluster.name"
+
+// TagLabelPrefix prefixes agent tag keys in metadata labels, e.g. agent.tag.env
+const TagLabelPrefix = "agent.tag."
+
+// Cluster is a kubernetes cluster along with the values of its agent tags and, once merged, the source listing it
+type Cluster struct {
+	Name   string            `json:"name"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Source string            `json:"source,omitempty"`
+}
+
+// NewCluster returns a cluster with every given tag key set, empty until a value is known, so that templates can
+// refer to any configured tag
+func NewCluster(name string, tagKeys []string) Cluster {
+	c := Cluster{Name: name, Tags: make(map[string]string, len(tagKeys))}
+	for _, key := range tagKeys {
+		c.Tags[key] = ""
+	}
+	return c
+}
+
+// TagLabel returns the metadata label of an agent tag key
+func TagLabel(key string) string {
+	return TagLabelPrefix + key
+}
+
+// TagLabels returns the metadata labels of the given agent tag keys
+func TagLabels(tagKeys []string) []string {
+	labels := make([]string, 0, len(tagKeys))
+	for _, key := range tagKeys {
+		labels = append(labels, TagLabel(key))
+	}
+	return labels
+}
+
+// Tag returns the value of an agent tag, or an empty string when it is unknown
+func (c Cluster) Tag(key string) string {
+	return c.Tags[key]
+}
+
+// TagString renders the tags as sorted, comma-separated key=value pairs, skipping empty values
+func (c Cluster) TagString() string {
+	var parts []string
+	for key, value := range c.Tags {
+		if value != "" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// TagConflict records the agents of a cluster reporting different values for a tag
+type TagConflict struct {
+	Cluster string
+	Key     string
+	// Values holds every value reported, sorted, the first being the one kept
+	Values []string
+}
+
+// FromMetadata builds clusters from metadata rows carrying the cluster name and agent.tag.<key> labels, in the order
+// the clusters first appear.  Agents of the same cluster may report different values, the lowest non-empty value of
+// each tag is kept so that the result does not depend on the order of the rows, and the conflicts are returned for
+// the caller to report.  Rows without a cluster name are ignored.
+func FromMetadata(rows []metadata.DataMetadataResult, tagKeys []string) ([]Cluster, []TagConflict) {
+	var clusters []Cluster
+	index := make(map[string]int)
+	values := make(map[string]map[string]bool)
+	for _, row := range rows {
+		name := row[LabelClusterName]
+		if name == "" {
+			continue
+		}
+		if _, ok := index[name]; !ok {
+			index[name] = len(clusters)
+			clusters = append(clusters, NewCluster(name, tagKeys))
+		}
+		for _, key := range tagKeys {
+			if value := row[TagLabel(key)]; value != "" {
+				id := name + "\x00" + key
+				if values[id] == nil {
+					values[id] = make(map[string]bool)
+				}
+				values[id][value] = true
+			}
+		}
+	}
+
+	var conflicts []TagConflict
+	for i := range clusters {
+		for _, key := range tagKeys {
+			reported := values[clusters[i].Name+"\x00"+key]
+			if len(reported) == 0 {
+				continue
+			}
+			sorted := make([]string, 0, len(reported))
+			for value := range reported {
+				sorted = append(sorted, value)
+			}
+			sort.Strings(sorted)
+			clusters[i].Tags[key] = sorted[0]
+			if len(sorted) > 1 {
+				conflicts = append(conflicts, TagConflict{Cluster: clusters[i].Name, Key: key, Values: sorted})
+			}
+		}
+	}
+	return clusters, conflicts
+}
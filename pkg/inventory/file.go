@@ -0,0 +1,121 @@
+package inventory
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSource lists the clusters of an inventory file, read on every call so that edits are picked up without a
+// restart.  Files ending in .csv hold a header row starting with a name column, the other columns being tags:
+//
+//	name,env,team
+//	prod-1,prod,payments
+//
+// Other files are YAML:
+//
+//	clusters:
+//	  - name: prod-1
+//	    tags: {env: prod, team: payments}
+//
+// A file listing no cluster is an error.
+type FileSource struct {
+	path string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (s *FileSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s *FileSource) Clusters(ctx context.Context) ([]Cluster, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var clusters []Cluster
+	if strings.EqualFold(filepath.Ext(s.path), ".csv") {
+		clusters, err = readCSV(f)
+	} else {
+		clusters, err = readYAML(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read '%s': %w", s.path, err)
+	}
+	// An empty or truncated file is more likely a failed write than an inventory without clusters
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("inventory '%s' lists no clusters", s.path)
+	}
+	return clusters, nil
+}
+
+type yamlInventory struct {
+	Clusters []struct {
+		Name string            `yaml:"name"`
+		Tags map[string]string `yaml:"tags"`
+	} `yaml:"clusters"`
+}
+
+func readYAML(r io.Reader) ([]Cluster, error) {
+	var inventory yamlInventory
+	if err := yaml.NewDecoder(r).Decode(&inventory); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	clusters := make([]Cluster, 0, len(inventory.Clusters))
+	for i, entry := range inventory.Clusters {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("cluster %d is missing a name", i)
+		}
+		clusters = append(clusters, Cluster{Name: entry.Name, Tags: entry.Tags})
+	}
+	return clusters, nil
+}
+
+func readCSV(r io.Reader) ([]Cluster, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 || strings.TrimSpace(header[0]) != "name" {
+		return nil, errors.New("expected a header row starting with a 'name' column")
+	}
+
+	var clusters []Cluster
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return clusters, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := Cluster{Name: strings.TrimSpace(record[0]), Tags: make(map[string]string, len(header)-1)}
+		if c.Name == "" {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d is missing a cluster name", line)
+		}
+		for i, key := range header[1:] {
+			c.Tags[strings.TrimSpace(key)] = strings.TrimSpace(record[i+1])
+		}
+		clusters = append(clusters, c)
+	}
+}
@@ -0,0 +1,145 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Inventory Suite")
+}
+
+// failingSource is a cluster source that cannot be read
+type failingSource struct{}
+
+func (failingSource) Name() string {
+	return "failing"
+}
+
+func (failingSource) Clusters(ctx context.Context) ([]Cluster, error) {
+	return nil, errors.New("unreachable")
+}
+
+func writeFile(dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	gomega.Expect(os.WriteFile(path, []byte(content), 0o600)).Should(gomega.Succeed())
+	return path
+}
+
+var _ = ginkgo.Describe("Inventory", func() {
+	ginkgo.It("should build clusters from metadata rows, keeping the lowest value of each tag", func() {
+		rows := []metadata.DataMetadataResult{
+			{"kubernetes.cluster.name": "prod-1", "agent.tag.env": "staging"},
+			{"kubernetes.cluster.name": "prod-1", "agent.tag.env": "prod", "agent.tag.team": "payments"},
+			{"kubernetes.cluster.name": "prod-1", "agent.tag.env": "staging"},
+			{"kubernetes.cluster.name": "dev-1"},
+			{"agent.tag.env": "orphan"},
+		}
+
+		expected := []Cluster{
+			{Name: "prod-1", Tags: map[string]string{"env": "prod", "team": "payments"}},
+			{Name: "dev-1", Tags: map[string]string{"env": "", "team": ""}},
+		}
+		clusters, conflicts := FromMetadata(rows, []string{"env", "team"})
+		gomega.Expect(clusters).Should(gomega.Equal(expected))
+		gomega.Expect(conflicts).Should(gomega.Equal([]TagConflict{{Cluster: "prod-1", Key: "env", Values: []string{"prod", "staging"}}}))
+
+		// The backend's row order does not change the values kept
+		rows[0], rows[1] = rows[1], rows[0]
+		clusters, _ = FromMetadata(rows, []string{"env", "team"})
+		gomega.Expect(clusters).Should(gomega.Equal(expected))
+	})
+
+	ginkgo.It("should render tags as sorted key=value pairs", func() {
+		c := Cluster{Name: "prod-1", Tags: map[string]string{"team": "payments", "env": "prod", "region": ""}}
+		gomega.Expect(c.TagString()).Should(gomega.Equal("env=prod,team=payments"))
+		gomega.Expect(c.Tag("region")).Should(gomega.BeEmpty())
+		gomega.Expect(TagLabels([]string{"env"})).Should(gomega.Equal([]string{"agent.tag.env"}))
+	})
+
+	ginkgo.It("should merge sources in order of precedence", func() {
+		first := NewStaticSource([]Cluster{{Name: "prod-1", Tags: map[string]string{"env": "prod", "team": ""}}})
+		second := NewStaticSource([]Cluster{
+			{Name: "new-1", Tags: map[string]string{"env": "dev"}},
+			{Name: "prod-1", Tags: map[string]string{"env": "staging", "team": "payments"}},
+		})
+
+		clusters, err := Merge(context.Background(), first, second)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(clusters).Should(gomega.Equal([]Cluster{
+			{Name: "prod-1", Source: "static", Tags: map[string]string{"env": "prod", "team": "payments"}},
+			{Name: "new-1", Source: "static", Tags: map[string]string{"env": "dev"}},
+		}))
+
+		_, err = Merge(context.Background(), first, failingSource{})
+		gomega.Expect(err).Should(gomega.MatchError("cluster source 'failing': unreachable"))
+	})
+
+	ginkgo.It("should read YAML and CSV inventory files", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		yamlPath := writeFile(dir, "inventory.yaml", "clusters:\n  - name: prod-1\n    tags: {env: prod}\n  - name: prod-2\n")
+		csvPath := writeFile(dir, "inventory.csv", "name,env,team\n# onboarding\nprod-3, prod, payments\n")
+
+		clusters, err := NewFileSource(yamlPath).Clusters(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(clusters).Should(gomega.Equal([]Cluster{{Name: "prod-1", Tags: map[string]string{"env": "prod"}}, {Name: "prod-2"}}))
+
+		clusters, err = NewFileSource(csvPath).Clusters(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(clusters).Should(gomega.Equal([]Cluster{{Name: "prod-3", Tags: map[string]string{"env": "prod", "team": "payments"}}}))
+
+		badPath := writeFile(dir, "bad.csv", "cluster,env\nprod-4,prod\n")
+		_, err = NewFileSource(badPath).Clusters(context.Background())
+		gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("'name' column")))
+
+		for name, content := range map[string]string{"empty.yaml": "", "truncated.yaml": "clusters:\n", "empty.csv": "name,env\n"} {
+			path := writeFile(dir, name, content)
+			_, err = NewFileSource(path).Clusters(context.Background())
+			gomega.Expect(err).Should(gomega.MatchError(fmt.Sprintf("inventory '%s' lists no clusters", path)))
+		}
+	})
+
+	ginkgo.It("should list the clusters of a directory of kubeconfig files", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		writeFile(dir, "prod.yaml", `
+apiVersion: v1
+kind: Config
+clusters:
+  - name: prod-cluster
+    cluster: {server: "https://prod.example.com"}
+contexts:
+  - name: prod-admin
+    context: {cluster: prod-cluster, user: admin}
+users:
+  - name: admin
+    user: {token: secret}
+`)
+		writeFile(dir, "dev.yaml", "contexts:\n  - name: dev-admin\n    context: {cluster: dev-cluster}\n")
+		writeFile(dir, ".hidden", "not: [yaml")
+		gomega.Expect(os.Mkdir(filepath.Join(dir, "archive"), 0o700)).Should(gomega.Succeed())
+		// Kubeconfigs mounted from a Secret are links into the hidden ..data directory
+		gomega.Expect(os.Mkdir(filepath.Join(dir, "..data"), 0o700)).Should(gomega.Succeed())
+		writeFile(filepath.Join(dir, "..data"), "staging.yaml", "contexts:\n  - name: staging-admin\n    context: {cluster: staging-cluster}\n")
+		gomega.Expect(os.Symlink(filepath.Join("..data", "staging.yaml"), filepath.Join(dir, "staging.yaml"))).Should(gomega.Succeed())
+
+		clusters, err := NewKubeconfigSource(dir, "").Clusters(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(clusters).Should(gomega.Equal([]Cluster{{Name: "dev-cluster"}, {Name: "prod-cluster"}, {Name: "staging-cluster"}}))
+
+		clusters, err = NewKubeconfigSource(dir, KubeconfigNameFromContext).Clusters(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(clusters).Should(gomega.Equal([]Cluster{{Name: "dev-admin"}, {Name: "prod-admin"}, {Name: "staging-admin"}}))
+
+		empty := ginkgo.GinkgoT().TempDir()
+		_, err = NewKubeconfigSource(empty, "").Clusters(context.Background())
+		gomega.Expect(err).Should(gomega.MatchError(fmt.Sprintf("no kubeconfig context found in '%s'", empty)))
+	})
+})
@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kubeconfig cluster naming, see KubeconfigSource
+const (
+	KubeconfigNameFromCluster = "cluster"
+	KubeconfigNameFromContext = "context"
+)
+
+// KubeconfigSource lists a cluster for every context of the kubeconfig files in a directory.  Only the context and
+// cluster names are read, no connection is made to the clusters.  Clusters are named after the kubeconfig cluster,
+// or after the context when nameFrom is KubeconfigNameFromContext, which should match the kubernetes.cluster.name the
+// agents report.
+type KubeconfigSource struct {
+	dir      string
+	nameFrom string
+}
+
+func NewKubeconfigSource(dir string, nameFrom string) *KubeconfigSource {
+	if nameFrom == "" {
+		nameFrom = KubeconfigNameFromCluster
+	}
+	return &KubeconfigSource{dir: dir, nameFrom: nameFrom}
+}
+
+func (s *KubeconfigSource) Name() string {
+	return "kubeconfig:" + s.dir
+}
+
+type kubeconfig struct {
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// Clusters reads every non-hidden file of the directory in name order.  Symbolic links to files are followed, as
+// kubeconfigs mounted from a Kubernetes Secret or ConfigMap are links into the hidden ..data directory.  A directory
+// without any context is an error, so that a bad mount is never mistaken for every cluster being removed.
+func (s *KubeconfigSource) Clusters(ctx context.Context) ([]Cluster, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []Cluster
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var config kubeconfig
+		if err = yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("could not parse kubeconfig '%s': %w", path, err)
+		}
+		for _, kubeContext := range config.Contexts {
+			name := kubeContext.Context.Cluster
+			if s.nameFrom == KubeconfigNameFromContext {
+				name = kubeContext.Name
+			}
+			if name != "" {
+				clusters = append(clusters, Cluster{Name: name})
+			}
+		}
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no kubeconfig context found in '%s'", s.dir)
+	}
+	return clusters, nil
+}
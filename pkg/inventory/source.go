@@ -0,0 +1,65 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+)
+
+// ClusterSource lists the clusters known to one source of truth, such as the metadata API or an inventory file
+type ClusterSource interface {
+	// Name identifies the source in errors and listings, e.g. "metadata" or "file:inventory.csv"
+	Name() string
+	Clusters(ctx context.Context) ([]Cluster, error)
+}
+
+// Merge lists the clusters of every source, given in order of precedence.  A cluster listed by several sources is
+// reported once, in the position the first source listing it gave it, with Source naming that source.  Each tag takes
+// the first non-empty value in order of precedence.  Any failing source fails the merge, so that a cluster missing
+// from an unreachable source is never mistaken for a removed cluster.
+func Merge(ctx context.Context, sources ...ClusterSource) ([]Cluster, error) {
+	var merged []Cluster
+	index := make(map[string]int)
+	for _, source := range sources {
+		clusters, err := source.Clusters(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cluster source '%s': %w", source.Name(), err)
+		}
+		for _, c := range clusters {
+			i, ok := index[c.Name]
+			if !ok {
+				i = len(merged)
+				index[c.Name] = i
+				merged = append(merged, Cluster{Name: c.Name, Source: source.Name(), Tags: make(map[string]string, len(c.Tags))})
+			}
+			for key, value := range c.Tags {
+				if current, set := merged[i].Tags[key]; !set || current == "" {
+					merged[i].Tags[key] = value
+				}
+			}
+		}
+	}
+	return merged, nil
+}
+
+// StaticSource lists clusters given in the configuration, e.g. clusters being onboarded that have not reported
+// metrics yet
+type StaticSource struct {
+	clusters []Cluster
+}
+
+func NewStaticSource(clusters []Cluster) *StaticSource {
+	return &StaticSource{clusters: clusters}
+}
+
+func (s *StaticSource) Name() string {
+	return "static"
+}
+
+func (s *StaticSource) Clusters(ctx context.Context) ([]Cluster, error) {
+	for i, c := range s.clusters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("cluster %d is missing a name", i)
+		}
+	}
+	return s.clusters, nil
+}
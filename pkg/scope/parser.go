@@ -0,0 +1,264 @@
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenEquals
+	tokenNotEquals
+	tokenOpenParen
+	tokenCloseParen
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	start int
+}
+
+func (t token) is(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+// isWordRune reports whether r can be part of a label or keyword, e.g. kubernetes.cluster.name or agent.tag.env-name
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-/:", r)
+}
+
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '=':
+			tokens = append(tokens, token{kind: tokenEquals, text: "=", start: i})
+			i++
+		case r == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, token{kind: tokenNotEquals, text: "!=", start: i})
+			i += 2
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpenParen, text: "(", start: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenCloseParen, text: ")", start: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", start: i})
+			i++
+		case r == '"' || r == '\'':
+			var value strings.Builder
+			start := i
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					value.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == r {
+					closed = true
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string starting at offset %d in scope '%s'", start, text)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value.String(), start: start})
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i]), start: start})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at offset %d in scope '%s'", r, i, text)
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, error) {
+	t, ok := p.peek()
+	if !ok {
+		return token{}, errors.New("unexpected end of scope")
+	}
+	p.pos++
+	return t, nil
+}
+
+// parseExpression parses condition ("and" condition)*
+func (p *parser) parseExpression() (Expression, error) {
+	var expression Expression
+	if _, ok := p.peek(); !ok {
+		return expression, nil
+	}
+	for {
+		condition, err := p.parseCondition()
+		if err != nil {
+			return Expression{}, err
+		}
+		expression.Conditions = append(expression.Conditions, condition)
+
+		t, ok := p.peek()
+		if !ok {
+			return expression, nil
+		}
+		if !t.is("and") {
+			return Expression{}, fmt.Errorf("expected 'and' at offset %d, found '%s'", t.start, t.text)
+		}
+		p.pos++
+	}
+}
+
+// parseCondition parses key operator value, or key [not] in (value, ...).  Operators are =, !=, contains, does not
+// contain, starts with and not starts with.
+func (p *parser) parseCondition() (Condition, error) {
+	key, err := p.next()
+	if err != nil {
+		return Condition{}, err
+	}
+	if key.kind != tokenWord || key.is("and") {
+		return Condition{}, fmt.Errorf("expected a label at offset %d, found '%s'", key.start, key.text)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return Condition{}, err
+	}
+	condition := Condition{Key: key.text}
+	switch {
+	case op.kind == tokenEquals:
+		condition.Operator = Equals
+	case op.kind == tokenNotEquals:
+		condition.Operator = NotEquals
+	case op.is("in"):
+		condition.Operator = In
+	case op.is("contains"):
+		condition.Operator = Contains
+	case op.is("does"):
+		if err = p.expectWord("not"); err != nil {
+			return Condition{}, err
+		}
+		if err = p.expectWord("contain"); err != nil {
+			return Condition{}, err
+		}
+		condition.Operator = DoesNotContain
+	case op.is("not"):
+		negated, err := p.next()
+		if err != nil {
+			return Condition{}, err
+		}
+		switch {
+		case negated.is("in"):
+			condition.Operator = NotIn
+		case negated.is("starts"):
+			if err = p.expectWord("with"); err != nil {
+				return Condition{}, err
+			}
+			condition.Operator = NotStartsWith
+		default:
+			return Condition{}, fmt.Errorf("expected 'in' or 'starts with' at offset %d, found '%s'", negated.start, negated.text)
+		}
+	case op.is("starts"):
+		if err = p.expectWord("with"); err != nil {
+			return Condition{}, err
+		}
+		condition.Operator = StartsWith
+	default:
+		return Condition{}, fmt.Errorf("expected an operator after '%s' at offset %d, found '%s'", key.text, op.start, op.text)
+	}
+
+	if condition.Operator == In || condition.Operator == NotIn {
+		condition.Values, err = p.parseList()
+	} else {
+		var value string
+		value, err = p.parseValue()
+		condition.Values = []string{value}
+	}
+	if err != nil {
+		return Condition{}, err
+	}
+	return condition, nil
+}
+
+func (p *parser) expectWord(keyword string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if !t.is(keyword) {
+		return fmt.Errorf("expected '%s' at offset %d, found '%s'", keyword, t.start, t.text)
+	}
+	return nil
+}
+
+// parseValue accepts a quoted string, or a bare word for backends that accept unquoted values
+func (p *parser) parseValue() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != tokenString && (t.kind != tokenWord || t.is("and")) {
+		return "", fmt.Errorf("expected a value at offset %d, found '%s'", t.start, t.text)
+	}
+	return t.text, nil
+}
+
+// parseList parses (value, ...)
+func (p *parser) parseList() ([]string, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenOpenParen {
+		return nil, fmt.Errorf("expected '(' at offset %d, found '%s'", t.start, t.text)
+	}
+	var values []string
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		t, err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.kind {
+		case tokenComma:
+			continue
+		case tokenCloseParen:
+			return values, nil
+		}
+		return nil, fmt.Errorf("expected ',' or ')' at offset %d, found '%s'", t.start, t.text)
+	}
+}
@@ -0,0 +1,139 @@
+// Package scope parses Sysdig scope expressions, such as kubernetes.cluster.name = "prod" and
+// kubernetes.namespace.name in ("a", "b"), so that scopes can be compared by meaning rather than by spelling.
+package scope
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals         Operator = "="
+	NotEquals      Operator = "!="
+	In             Operator = "in"
+	NotIn          Operator = "not in"
+	Contains       Operator = "contains"
+	DoesNotContain Operator = "does not contain"
+	StartsWith     Operator = "starts with"
+	NotStartsWith  Operator = "not starts with"
+)
+
+// Condition is a single comparison of a label against one or more values
+type Condition struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Expression is a conjunction of conditions, the only form of scope Sysdig supports.  An empty expression matches
+// everything.
+type Expression struct {
+	Conditions []Condition
+}
+
+// Parse parses a scope expression.  Keywords are case-insensitive, and values may be quoted with double or single
+// quotes, using a backslash to escape the quote character or a backslash.
+func Parse(text string) (Expression, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return Expression{}, err
+	}
+	p := &parser{tokens: tokens}
+	expression, err := p.parseExpression()
+	if err != nil {
+		return Expression{}, fmt.Errorf("invalid scope '%s': %v", text, err)
+	}
+	return expression, nil
+}
+
+// Normalize returns the canonical form of an expression: single-value in and not in become = and !=, the values of
+// in and not in are sorted and deduplicated, and conditions are sorted and deduplicated
+func (e Expression) Normalize() Expression {
+	var conditions []Condition
+	seen := make(map[string]bool)
+	for _, condition := range e.Conditions {
+		normalized := Condition{Key: condition.Key, Operator: condition.Operator, Values: append([]string{}, condition.Values...)}
+		if normalized.Operator == In || normalized.Operator == NotIn {
+			normalized.Values = uniqueSorted(normalized.Values)
+			if len(normalized.Values) == 1 {
+				if normalized.Operator == In {
+					normalized.Operator = Equals
+				} else {
+					normalized.Operator = NotEquals
+				}
+			}
+		}
+		if key := normalized.String(); !seen[key] {
+			seen[key] = true
+			conditions = append(conditions, normalized)
+		}
+	}
+	sort.Slice(conditions, func(i, j int) bool {
+		return conditions[i].String() < conditions[j].String()
+	})
+	return Expression{Conditions: conditions}
+}
+
+// String renders the expression with double-quoted values
+func (e Expression) String() string {
+	parts := make([]string, 0, len(e.Conditions))
+	for _, condition := range e.Conditions {
+		parts = append(parts, condition.String())
+	}
+	return strings.Join(parts, " and ")
+}
+
+func (c Condition) String() string {
+	if c.Operator == In || c.Operator == NotIn {
+		quoted := make([]string, 0, len(c.Values))
+		for _, value := range c.Values {
+			quoted = append(quoted, Quote(value))
+		}
+		return fmt.Sprintf("%s %s (%s)", c.Key, c.Operator, strings.Join(quoted, ", "))
+	}
+	value := ""
+	if len(c.Values) > 0 {
+		value = c.Values[0]
+	}
+	return fmt.Sprintf("%s %s %s", c.Key, c.Operator, Quote(value))
+}
+
+// Equal reports whether two expressions select the same entities once normalized
+func Equal(a Expression, b Expression) bool {
+	return a.Normalize().String() == b.Normalize().String()
+}
+
+// EqualStrings reports whether two scope strings are equivalent.  When either fails to parse the strings are compared
+// as they are.
+func EqualStrings(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	exprA, errA := Parse(a)
+	exprB, errB := Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return Equal(exprA, exprB)
+}
+
+// Quote returns value as a double-quoted scope string, escaping backslashes and double quotes
+func Quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
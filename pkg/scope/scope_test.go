@@ -0,0 +1,78 @@
+package scope
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Scope Suite")
+}
+
+var _ = ginkgo.Describe("Scope", func() {
+	ginkgo.It("should parse every operator", func() {
+		expression, err := Parse(`kubernetes.cluster.name = "prod" and kubernetes.namespace.name != 'kube-system' and ` +
+			`container.image.repo IN ("a", "b") and host.hostName not in ("c") and kubernetes.pod.name contains "api" ` +
+			`and agent.tag.env starts with "eu-" and kubernetes.pod.name does not contain "debug" and ` +
+			`kubernetes.namespace.name NOT STARTS WITH "kube-"`)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(expression.Conditions).Should(gomega.Equal([]Condition{
+			{Key: "kubernetes.cluster.name", Operator: Equals, Values: []string{"prod"}},
+			{Key: "kubernetes.namespace.name", Operator: NotEquals, Values: []string{"kube-system"}},
+			{Key: "container.image.repo", Operator: In, Values: []string{"a", "b"}},
+			{Key: "host.hostName", Operator: NotIn, Values: []string{"c"}},
+			{Key: "kubernetes.pod.name", Operator: Contains, Values: []string{"api"}},
+			{Key: "agent.tag.env", Operator: StartsWith, Values: []string{"eu-"}},
+			{Key: "kubernetes.pod.name", Operator: DoesNotContain, Values: []string{"debug"}},
+			{Key: "kubernetes.namespace.name", Operator: NotStartsWith, Values: []string{"kube-"}},
+		}))
+	})
+
+	ginkgo.It("should treat differently spelled scopes as equal", func() {
+		for _, spelling := range []string{
+			`kubernetes.cluster.name = "prod"`,
+			`kubernetes.cluster.name="prod"`,
+			`kubernetes.cluster.name = 'prod'`,
+			`kubernetes.cluster.name in ("prod")`,
+			`kubernetes.cluster.name in ("prod", "prod")`,
+		} {
+			gomega.Expect(EqualStrings(`kubernetes.cluster.name = "prod"`, spelling)).Should(gomega.BeTrue(), spelling)
+		}
+		gomega.Expect(EqualStrings(`a = "1" and b in ("y", "x")`, `b in ('x','y') and a = "1"`)).Should(gomega.BeTrue())
+		gomega.Expect(EqualStrings(`kubernetes.cluster.name = "prod"`, `kubernetes.cluster.name = "prod-2"`)).Should(gomega.BeFalse())
+		gomega.Expect(EqualStrings(`kubernetes.cluster.name = "prod"`, `kubernetes.cluster.name != "prod"`)).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should quote values so that they parse back unchanged", func() {
+		name := `we"ird\name`
+		expression, err := Parse("kubernetes.cluster.name = " + Quote(name))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(expression.Conditions[0].Values).Should(gomega.Equal([]string{name}))
+		gomega.Expect(expression.String()).Should(gomega.Equal(`kubernetes.cluster.name = "we\"ird\\name"`))
+	})
+
+	ginkgo.It("should parse an empty scope as matching everything", func() {
+		expression, err := Parse("  ")
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(expression.Conditions).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should reject malformed scopes", func() {
+		for _, text := range []string{
+			`kubernetes.cluster.name = "prod`,
+			`kubernetes.cluster.name "prod"`,
+			`kubernetes.cluster.name = "prod" or a = "b"`,
+			`kubernetes.cluster.name in "prod"`,
+			`kubernetes.cluster.name in ("a" "b")`,
+			`kubernetes.cluster.name starts "eu"`,
+			`kubernetes.cluster.name does contain "eu"`,
+			`kubernetes.cluster.name not contains "eu"`,
+			`kubernetes.cluster.name = "a" and`,
+		} {
+			_, err := Parse(text)
+			gomega.Expect(err).Should(gomega.HaveOccurred(), text)
+		}
+	})
+})
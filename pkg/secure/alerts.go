@@ -0,0 +1,58 @@
+package secure
+
+import (
+	"context"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"net/url"
+)
+
+const (
+	alertsPath       = "/api/scanning/v1/alerts"
+	alertsPermission = "scanning alerts"
+)
+
+// AlertsService manages scanning alerts
+type AlertsService struct {
+	client *Client
+}
+
+func (s *AlertsService) List(ctx context.Context) (*alerts.AlertQuery, error) {
+	result := &alerts.AlertQuery{}
+	if err := s.client.do(ctx, "list alerts", alertsPermission, "GET", alertsPath, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AlertsService) Get(ctx context.Context, alertID string) (*alerts.Alert, error) {
+	result := &alerts.Alert{}
+	if err := s.client.do(ctx, fmt.Sprintf("get alert %s", alertID), alertsPermission, "GET", alertPath(alertID), nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AlertsService) Create(ctx context.Context, payload alerts.PayloadAlert) (*alerts.Alert, error) {
+	result := &alerts.Alert{}
+	if err := s.client.do(ctx, fmt.Sprintf("create alert '%s'", payload.Name), alertsPermission, "POST", alertsPath, payload, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AlertsService) Update(ctx context.Context, alertID string, payload alerts.PayloadAlert) (*alerts.Alert, error) {
+	result := &alerts.Alert{}
+	if err := s.client.do(ctx, fmt.Sprintf("update alert %s", alertID), alertsPermission, "PUT", alertPath(alertID), payload, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AlertsService) Delete(ctx context.Context, alertID string) error {
+	return s.client.do(ctx, fmt.Sprintf("delete alert %s", alertID), alertsPermission, "DELETE", alertPath(alertID), nil, nil)
+}
+
+func alertPath(alertID string) string {
+	return fmt.Sprintf("%s/%s", alertsPath, url.PathEscape(alertID))
+}
@@ -0,0 +1,69 @@
+// Package secure is a typed client for the Sysdig Secure APIs used to manage scanning alerts, built on
+// sysdighttp.SysdigClient.
+package secure
+
+import (
+	"context"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/sysdighttp"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// Client groups the typed services of the Sysdig Secure API for a single backend
+type Client struct {
+	http    sysdighttp.SysdigClient
+	logger  *logrus.Logger
+	baseURL string
+	token   string
+
+	Alerts               *AlertsService
+	Metadata             *MetadataService
+	NotificationChannels *NotificationChannelsService
+}
+
+func NewClient(logger *logrus.Logger, httpClient sysdighttp.SysdigClient, baseURL string, token string) *Client {
+	c := &Client{
+		http:    httpClient,
+		logger:  logger,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+	}
+	c.Alerts = &AlertsService{client: c}
+	c.Metadata = &MetadataService{client: c}
+	c.NotificationChannels = &NotificationChannelsService{client: c}
+	return c
+}
+
+// do issues a request against the API, sending body as JSON when it is not nil and decoding the response into
+// target when it is not nil.  Failures are returned as *Error, naming the permission the call requires.
+func (c *Client) do(ctx context.Context, operation string, permission string, method string, path string, body interface{}, target interface{}) error {
+	config := sysdighttp.DefaultSysdigRequestConfig(fmt.Sprintf("%s%s", c.baseURL, path), c.token)
+	config.Method = method
+	if body != nil {
+		config.Headers = map[string]string{
+			"Content-Type": "application/json",
+		}
+		config.JSON = body
+	}
+
+	var err error
+	var resp *http.Response
+	if resp, err = c.http.SysdigRequestWithContext(ctx, c.logger, config); err != nil {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		return &Error{Operation: operation, Permission: permission, StatusCode: statusCode, Err: err}
+	}
+
+	if target == nil {
+		_ = resp.Body.Close()
+		return nil
+	}
+	if err = c.http.ResponseBodyToJson(resp, target); err != nil {
+		return &Error{Operation: operation, Permission: permission, StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to decode response: %w", err)}
+	}
+	return nil
+}
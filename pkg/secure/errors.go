@@ -0,0 +1,70 @@
+package secure
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors matched by *Error through errors.Is, according to the HTTP status of the failed call
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+// Error is returned by every service call that fails, identifying the operation and, when the backend answered,
+// the HTTP status code.  Permission names the Sysdig permission the call needs, and is used to explain 403s.
+type Error struct {
+	Operation  string
+	Permission string
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if hint := e.Hint(); hint != "" {
+		return fmt.Sprintf("%s: %s: %v", e.Operation, hint, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Operation, e.Err)
+}
+
+// Hint returns an actionable explanation of the failure for the statuses users can fix themselves, or an
+// empty string
+func (e *Error) Hint() string {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return "the API token is invalid or expired, check secure_api_token"
+	case http.StatusForbidden:
+		if e.Permission != "" {
+			return fmt.Sprintf("the API token lacks the %s permission", e.Permission)
+		}
+		return "the API token lacks the required permission"
+	case http.StatusNotFound:
+		return "the resource does not exist, or secure_url does not point at a Sysdig Secure API"
+	case http.StatusConflict:
+		return "the resource was modified concurrently or already exists"
+	case http.StatusTooManyRequests:
+		return "rate limited by the API, retry later"
+	}
+	return ""
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package secure
+
+import (
+	"context"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"strings"
+	"time"
+)
+
+const metadataPermission = "data access (entity metadata)"
+
+const defaultMetadataPageSize = 1000
+
+// MetadataService queries entity metadata, such as the kubernetes clusters reporting to the backend
+type MetadataService struct {
+	client *Client
+}
+
+func (s *MetadataService) Query(ctx context.Context, payload metadata.PayloadMetadata) (*metadata.ResultMetadata, error) {
+	result := &metadata.ResultMetadata{}
+	if err := s.client.do(ctx, "query metadata", metadataPermission, "POST", "/api/data/entity/metadata", payload, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MetadataQuery describes the entities to list: the labels to group them by, such as kubernetes.cluster.name,
+// agent.tag.env or host.hostName, an optional scope filter and an optional time range
+type MetadataQuery struct {
+	labels   []string
+	filter   string
+	time     *metadata.TimeRangePayload
+	pageSize int
+}
+
+// NewMetadataQuery returns a query returning one row per distinct combination of the given labels
+func NewMetadataQuery(labels ...string) *MetadataQuery {
+	return &MetadataQuery{labels: append([]string{}, labels...), pageSize: defaultMetadataPageSize}
+}
+
+// Filter restricts the query to the entities matching a scope expression, e.g. agent.tag.env = "prod"
+func (q *MetadataQuery) Filter(filter string) *MetadataQuery {
+	q.filter = filter
+	return q
+}
+
+// TimeRange restricts the query to the entities seen between from and to
+func (q *MetadataQuery) TimeRange(from time.Time, to time.Time) *MetadataQuery {
+	q.time = &metadata.TimeRangePayload{From: from.UnixMicro(), To: to.UnixMicro()}
+	return q
+}
+
+// PageSize sets the number of rows requested per page, ignoring sizes below one
+func (q *MetadataQuery) PageSize(size int) *MetadataQuery {
+	if size > 0 {
+		q.pageSize = size
+	}
+	return q
+}
+
+// Labels returns the labels the query groups by
+func (q *MetadataQuery) Labels() []string {
+	return q.labels
+}
+
+// Payload returns the request for the page of rows starting at from
+func (q *MetadataQuery) Payload(from int) metadata.PayloadMetadata {
+	return metadata.PayloadMetadata{
+		Time:    q.time,
+		Filter:  q.filter,
+		Metrics: q.labels,
+		Paging: metadata.PagingPayload{
+			From: from,
+			To:   from + q.pageSize - 1,
+		},
+	}
+}
+
+// Rows pages through a query until the reported total is reached, returning each distinct combination of label values
+// once, in the order the backend first returned them.  Pages advance by the number of rows actually returned, as the
+// backend may cap the page size, and a page coming back empty before the total is reached is an error rather than a
+// silently truncated result.
+func (s *MetadataService) Rows(ctx context.Context, q *MetadataQuery) (*metadata.ResultMetadata, error) {
+	rows := &metadata.ResultMetadata{}
+	seen := make(map[string]bool)
+	for from := 0; ; {
+		page, err := s.Query(ctx, q.Payload(from))
+		if err != nil {
+			return nil, err
+		}
+
+		rows.Metrics = page.Metrics
+		rows.Time = page.Time
+		rows.Paging = page.Paging
+		for _, row := range page.Data {
+			if key := strings.Join(row.Values(q.labels), "\x00"); !seen[key] {
+				seen[key] = true
+				rows.Data = append(rows.Data, row)
+			}
+		}
+		s.client.logger.Debugf("Retrieved metadata rows %d-%d of %d", from, from+len(page.Data)-1, page.Paging.Total)
+
+		from += len(page.Data)
+		if from >= page.Paging.Total {
+			return rows, nil
+		}
+		if len(page.Data) == 0 {
+			return nil, fmt.Errorf("metadata query returned an empty page after %d of %d rows", from, page.Paging.Total)
+		}
+	}
+}
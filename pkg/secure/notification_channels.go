@@ -0,0 +1,21 @@
+package secure
+
+import (
+	"context"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/notification"
+)
+
+const notificationChannelsPermission = "notification channels read"
+
+// NotificationChannelsService lists the notification channels alerts can be sent to
+type NotificationChannelsService struct {
+	client *Client
+}
+
+func (s *NotificationChannelsService) List(ctx context.Context) (*notification.ChannelQuery, error) {
+	result := &notification.ChannelQuery{}
+	if err := s.client.do(ctx, "list notification channels", notificationChannelsPermission, "GET", "/api/notificationChannels", nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
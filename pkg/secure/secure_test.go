@@ -0,0 +1,181 @@
+package secure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/loggerpkg"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/sysdighttp"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/alerts"
+	"github.com/aaronm-sysdig/alerts-by-cluster/structs/metadata"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Secure Suite")
+}
+
+var _ = ginkgo.Describe("Secure", func() {
+	var (
+		server *httptest.Server
+		client *Client
+		mux    *http.ServeMux
+	)
+
+	ginkgo.BeforeEach(func() {
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+		client = NewClient(loggerpkg.GetLogger(), sysdighttp.NewSysdigClient(), server.URL+"/", "token")
+	})
+
+	ginkgo.AfterEach(func() {
+		server.Close()
+	})
+
+	ginkgo.It("should list alerts", func() {
+		mux.HandleFunc("GET /api/scanning/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{"alerts":[{"alertId":"abc","name":"Cluster: aamiles-onprem5","scope":"kubernetes.cluster.name = \"aamiles-onprem5\""}]}`)
+		})
+
+		result, err := client.Alerts.List(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(len(result.Alerts)).Should(gomega.Equal(1))
+		gomega.Expect(result.Alerts[0].AlertID).Should(gomega.Equal("abc"))
+	})
+
+	ginkgo.It("should create an alert and return the created alert", func() {
+		mux.HandleFunc("POST /api/scanning/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+			payload := alerts.PayloadAlert{}
+			gomega.Expect(json.NewDecoder(r.Body).Decode(&payload)).Should(gomega.Succeed())
+			gomega.Expect(r.Header.Get("Content-Type")).Should(gomega.Equal("application/json"))
+			_ = json.NewEncoder(w).Encode(alerts.Alert{AlertID: "new", Name: payload.Name})
+		})
+
+		created, err := client.Alerts.Create(context.Background(), alerts.PayloadAlert{Name: "Cluster: aamiles-onprem5"})
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(created.AlertID).Should(gomega.Equal("new"))
+	})
+
+	ginkgo.It("should delete an alert by escaped ID", func() {
+		deleted := ""
+		mux.HandleFunc("DELETE /api/scanning/v1/alerts/{id}", func(w http.ResponseWriter, r *http.Request) {
+			deleted = r.PathValue("id")
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		gomega.Expect(client.Alerts.Delete(context.Background(), "a b")).Should(gomega.Succeed())
+		gomega.Expect(deleted).Should(gomega.Equal("a b"))
+	})
+
+	ginkgo.It("should return typed errors", func() {
+		mux.HandleFunc("GET /api/scanning/v1/alerts/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		_, err := client.Alerts.Get(context.Background(), "missing")
+		gomega.Expect(errors.Is(err, ErrNotFound)).Should(gomega.BeTrue())
+		gomega.Expect(errors.Is(err, ErrConflict)).Should(gomega.BeFalse())
+		var secureErr *Error
+		gomega.Expect(errors.As(err, &secureErr)).Should(gomega.BeTrue())
+		gomega.Expect(secureErr.Operation).Should(gomega.Equal("get alert missing"))
+	})
+
+	ginkgo.It("should explain permission failures", func() {
+		mux.HandleFunc("POST /api/scanning/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "req-456")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"message":"Access denied"}`)
+		})
+
+		_, err := client.Alerts.Create(context.Background(), alerts.PayloadAlert{Name: "Cluster: aamiles-onprem5"})
+		gomega.Expect(errors.Is(err, ErrForbidden)).Should(gomega.BeTrue())
+		gomega.Expect(err.Error()).Should(gomega.ContainSubstring("the API token lacks the scanning alerts permission"))
+		var apiErr *sysdighttp.APIError
+		gomega.Expect(errors.As(err, &apiErr)).Should(gomega.BeTrue())
+		gomega.Expect(apiErr.Message).Should(gomega.Equal("Access denied"))
+		gomega.Expect(apiErr.RequestID).Should(gomega.Equal("req-456"))
+	})
+
+	ginkgo.It("should list notification channels", func() {
+		mux.HandleFunc("GET /api/notificationChannels", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `{"notificationChannels":[{"id":10,"name":"PagerDuty Prod","type":"PAGER_DUTY","enabled":true}]}`)
+		})
+
+		result, err := client.NotificationChannels.List(context.Background())
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(result.NotificationChannels[0].ID).Should(gomega.Equal(10))
+	})
+
+	ginkgo.It("should page through metadata rows grouped by arbitrary labels", func() {
+		pages := []string{
+			`{"data":[{"agent.tag.env":"prod","host.hostName":"a"},{"agent.tag.env":"dev","host.hostName":"b"}],"paging":{"from":0,"to":1,"total":3}}`,
+			`{"data":[{"agent.tag.env":"prod","host.hostName":"a"}],"paging":{"from":2,"to":3,"total":3}}`,
+		}
+		var requested []metadata.PayloadMetadata
+		mux.HandleFunc("POST /api/data/entity/metadata", func(w http.ResponseWriter, r *http.Request) {
+			payload := metadata.PayloadMetadata{}
+			gomega.Expect(json.NewDecoder(r.Body).Decode(&payload)).Should(gomega.Succeed())
+			requested = append(requested, payload)
+			_, _ = io.WriteString(w, pages[len(requested)-1])
+		})
+
+		query := NewMetadataQuery("agent.tag.env", "host.hostName").Filter(`agent.tag.region = "eu"`).PageSize(2)
+		rows, err := client.Metadata.Rows(context.Background(), query)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(rows.Data).Should(gomega.Equal([]metadata.DataMetadataResult{
+			{"agent.tag.env": "prod", "host.hostName": "a"},
+			{"agent.tag.env": "dev", "host.hostName": "b"},
+		}))
+		gomega.Expect(len(requested)).Should(gomega.Equal(2))
+		gomega.Expect(requested[1].Paging).Should(gomega.Equal(metadata.PagingPayload{From: 2, To: 3}))
+		gomega.Expect(requested[0].Metrics).Should(gomega.Equal([]string{"agent.tag.env", "host.hostName"}))
+		gomega.Expect(requested[0].Filter).Should(gomega.Equal(`agent.tag.region = "eu"`))
+	})
+
+	ginkgo.It("should advance by the rows returned when the backend caps the page size", func() {
+		names := []string{"c0", "c1", "c2", "c3", "c4"}
+		var requested []metadata.PagingPayload
+		mux.HandleFunc("POST /api/data/entity/metadata", func(w http.ResponseWriter, r *http.Request) {
+			payload := metadata.PayloadMetadata{}
+			gomega.Expect(json.NewDecoder(r.Body).Decode(&payload)).Should(gomega.Succeed())
+			requested = append(requested, payload.Paging)
+			// At most 2 rows per page, whatever was asked for
+			to := min(payload.Paging.From+2, len(names))
+			page := metadata.ResultMetadata{Paging: metadata.PagingMetadataResult{From: payload.Paging.From, To: to - 1, Total: len(names)}}
+			for _, name := range names[payload.Paging.From:to] {
+				page.Data = append(page.Data, metadata.DataMetadataResult{"kubernetes.cluster.name": name})
+			}
+			gomega.Expect(json.NewEncoder(w).Encode(page)).Should(gomega.Succeed())
+		})
+
+		rows, err := client.Metadata.Rows(context.Background(), NewMetadataQuery("kubernetes.cluster.name").PageSize(3))
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		var discovered []string
+		for _, row := range rows.Data {
+			discovered = append(discovered, row.KubernetesClusterName())
+		}
+		gomega.Expect(discovered).Should(gomega.Equal(names))
+		gomega.Expect(requested).Should(gomega.Equal([]metadata.PagingPayload{{From: 0, To: 2}, {From: 2, To: 4}, {From: 4, To: 6}}))
+	})
+
+	ginkgo.It("should fail when a page comes back empty before the total is reached", func() {
+		mux.HandleFunc("POST /api/data/entity/metadata", func(w http.ResponseWriter, r *http.Request) {
+			payload := metadata.PayloadMetadata{}
+			gomega.Expect(json.NewDecoder(r.Body).Decode(&payload)).Should(gomega.Succeed())
+			if payload.Paging.From == 0 {
+				_, _ = io.WriteString(w, `{"data":[{"kubernetes.cluster.name":"c0"}],"paging":{"from":0,"to":0,"total":3}}`)
+				return
+			}
+			_, _ = io.WriteString(w, `{"data":[],"paging":{"from":1,"to":1,"total":3}}`)
+		})
+
+		_, err := client.Metadata.Rows(context.Background(), NewMetadataQuery("kubernetes.cluster.name"))
+		gomega.Expect(err).Should(gomega.MatchError("metadata query returned an empty page after 1 of 3 rows"))
+	})
+})
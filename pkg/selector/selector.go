@@ -0,0 +1,92 @@
+package selector
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RegexPrefix marks a pattern as a regular expression rather than a glob
+const RegexPrefix = "regex:"
+
+// Selector matches names against an exact name, a glob such as "ci-*", or a regular expression prefixed with "regex:"
+type Selector struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// Filter decides which names are selected using include and exclude rules.  An empty include list selects every
+// name, and exclude rules always win over include rules.
+type Filter struct {
+	Include []Selector
+	Exclude []Selector
+}
+
+func Parse(pattern string) (Selector, error) {
+	if strings.HasPrefix(pattern, RegexPrefix) {
+		regex, err := regexp.Compile(strings.TrimPrefix(pattern, RegexPrefix))
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid regular expression '%s': %v", pattern, err)
+		}
+		return Selector{pattern: pattern, regex: regex}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return Selector{}, fmt.Errorf("invalid glob '%s': %v", pattern, err)
+	}
+	return Selector{pattern: pattern}, nil
+}
+
+func ParseAll(patterns []string) ([]Selector, error) {
+	selectors := make([]Selector, 0, len(patterns))
+	for _, pattern := range patterns {
+		selector, err := Parse(pattern)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+func NewFilter(include []string, exclude []string) (*Filter, error) {
+	var err error
+	filter := &Filter{}
+	if filter.Include, err = ParseAll(include); err != nil {
+		return nil, err
+	}
+	if filter.Exclude, err = ParseAll(exclude); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (s Selector) Match(name string) bool {
+	if s.regex != nil {
+		return s.regex.MatchString(name)
+	}
+	matched, _ := path.Match(s.pattern, name)
+	return matched
+}
+
+func (s Selector) String() string {
+	return s.pattern
+}
+
+// Evaluate reports whether a name is selected, and when it is not, the rule responsible
+func (f *Filter) Evaluate(name string) (bool, string) {
+	for _, selector := range f.Exclude {
+		if selector.Match(name) {
+			return false, fmt.Sprintf("excluded by rule '%s'", selector)
+		}
+	}
+	if len(f.Include) == 0 {
+		return true, ""
+	}
+	for _, selector := range f.Include {
+		if selector.Match(name) {
+			return true, ""
+		}
+	}
+	return false, "not matched by any include rule"
+}
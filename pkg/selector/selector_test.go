@@ -0,0 +1,41 @@
+package selector
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSuite(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Selector Suite")
+}
+
+var _ = ginkgo.Describe("Selector", func() {
+	ginkgo.It("should match exact names, globs and regular expressions", func() {
+		selectors, err := ParseAll([]string{"prod-a", "ci-*", "regex:^kind-[a-z]+$"})
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(selectors[0].Match("prod-a")).Should(gomega.BeTrue())
+		gomega.Expect(selectors[0].Match("prod-ab")).Should(gomega.BeFalse())
+		gomega.Expect(selectors[1].Match("ci-1234")).Should(gomega.BeTrue())
+		gomega.Expect(selectors[2].Match("kind-local")).Should(gomega.BeTrue())
+		gomega.Expect(selectors[2].Match("kind-123")).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should reject invalid patterns", func() {
+		_, err := Parse("regex:[")
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+		_, err = Parse("ci-[")
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should let exclude rules win over include rules", func() {
+		filter, err := NewFilter([]string{"prod-*"}, []string{"prod-ci-*"})
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		selected, _ := filter.Evaluate("prod-a")
+		gomega.Expect(selected).Should(gomega.BeTrue())
+		selected, reason := filter.Evaluate("prod-ci-1")
+		gomega.Expect(selected).Should(gomega.BeFalse())
+		gomega.Expect(reason).Should(gomega.Equal("excluded by rule 'prod-ci-*'"))
+	})
+})
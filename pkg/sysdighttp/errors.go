@@ -0,0 +1,100 @@
+package sysdighttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requestIDHeaders are the response headers checked, in order, for an identifier that Sysdig support can use to
+// trace a failed request
+var requestIDHeaders = []string{"X-Request-Id", "X-Sysdig-Request-Id", "X-Correlation-Id"}
+
+// maxErrorBodyLength bounds how much of an undecodable error body is kept as the error message
+const maxErrorBodyLength = 512
+
+// APIError is returned by SysdigRequest when the backend answers with a 4xx or 5xx status.  Use errors.As to
+// retrieve it from wrapped errors.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+	RequestID  string
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	if e.RequestID != "" {
+		msg = fmt.Sprintf("%s (request ID %s)", msg, e.RequestID)
+	}
+	return msg
+}
+
+// newAPIError builds an APIError from a failed response whose body has already been read
+func newAPIError(req *http.Request, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    errorMessage(body),
+		Body:       body,
+	}
+	if req != nil {
+		apiErr.Method = req.Method
+		// Never echo query parameters, they may carry filters or tokens that do not belong in logs
+		u := *req.URL
+		u.RawQuery = ""
+		apiErr.URL = u.String()
+	}
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			apiErr.RequestID = id
+			break
+		}
+	}
+	return apiErr
+}
+
+// errorMessage extracts the human-readable message from the error bodies returned by the Sysdig APIs, which
+// use either {"message": ...}, {"error": ...} or {"errors": [{"reason": ..., "message": ...}]}.  Bodies that are
+// not JSON are returned as-is, truncated.
+func errorMessage(body []byte) string {
+	var decoded struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+		Errors  []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		text := strings.TrimSpace(string(body))
+		if len(text) > maxErrorBodyLength {
+			text = text[:maxErrorBodyLength] + "..."
+		}
+		return text
+	}
+
+	var messages []string
+	for _, e := range decoded.Errors {
+		switch {
+		case e.Reason != "" && e.Message != "":
+			messages = append(messages, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+		case e.Message != "":
+			messages = append(messages, e.Message)
+		case e.Reason != "":
+			messages = append(messages, e.Reason)
+		}
+	}
+	if len(messages) > 0 {
+		return strings.Join(messages, "; ")
+	}
+	if decoded.Message != "" {
+		return decoded.Message
+	}
+	return decoded.Error
+}
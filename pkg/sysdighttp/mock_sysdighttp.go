@@ -5,6 +5,7 @@
 package sysdighttp
 
 import (
+	context "context"
 	http "net/http"
 	reflect "reflect"
 
@@ -63,3 +64,18 @@ func (mr *MockSysdigClientMockRecorder) SysdigRequest(logger, config interface{}
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SysdigRequest", reflect.TypeOf((*MockSysdigClient)(nil).SysdigRequest), logger, config)
 }
+
+// SysdigRequestWithContext mocks base method.
+func (m *MockSysdigClient) SysdigRequestWithContext(ctx context.Context, logger *logrus.Logger, config SysdigRequestConfig) (*http.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SysdigRequestWithContext", ctx, logger, config)
+	ret0, _ := ret[0].(*http.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SysdigRequestWithContext indicates an expected call of SysdigRequestWithContext.
+func (mr *MockSysdigClientMockRecorder) SysdigRequestWithContext(ctx, logger, config interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SysdigRequestWithContext", reflect.TypeOf((*MockSysdigClient)(nil).SysdigRequestWithContext), ctx, logger, config)
+}
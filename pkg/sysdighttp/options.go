@@ -0,0 +1,146 @@
+package sysdighttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures the transport owned by a SysdigClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	http2               bool
+	proxy               func(*http.Request) (*url.URL, error)
+	tlsConfig           *tls.Config
+	headers             map[string]string
+	userAgent           string
+	rateLimit           float64
+	rateBurst           int
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		maxIdleConns:        100,
+		maxIdleConnsPerHost: 10,
+		idleConnTimeout:     90 * time.Second,
+		http2:               true,
+		proxy:               http.ProxyFromEnvironment,
+		tlsConfig:           &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// WithMaxIdleConns sets the maximum number of idle keep-alive connections across all hosts
+func WithMaxIdleConns(n int) Option {
+	return func(o *clientOptions) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithMaxIdleConnsPerHost sets the maximum number of idle keep-alive connections kept per backend
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(o *clientOptions) {
+		o.maxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept before being closed
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.idleConnTimeout = timeout
+	}
+}
+
+// WithHTTP2 enables or disables HTTP/2 negotiation
+func WithHTTP2(enabled bool) Option {
+	return func(o *clientOptions) {
+		o.http2 = enabled
+	}
+}
+
+// WithProxy sets the function choosing the proxy for each request, http.ProxyFromEnvironment by default
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(o *clientOptions) {
+		o.proxy = proxy
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to verify the backend, see NewTLSConfig
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *clientOptions) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// WithHeaders adds static headers, such as those required by an API gateway, to every request.  Headers set on an
+// individual SysdigRequestConfig take precedence.
+func WithHeaders(headers map[string]string) Option {
+	return func(o *clientOptions) {
+		o.headers = headers
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithRateLimit limits the client to requestsPerSecond, allowing bursts of up to burst requests.  Retries count
+// against the limit.  A rate of zero or less disables limiting.  The application passes http.rate_limit and
+// http.rate_burst, which default to 10 requests per second with bursts of 10; a client built without this option is
+// not limited.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(o *clientOptions) {
+		o.rateLimit = requestsPerSecond
+		o.rateBurst = burst
+	}
+}
+
+// ProxyFunc returns a proxy function sending requests through httpsProxy, except for hosts matching noProxy (a
+// comma separated list in the NO_PROXY format).  Each setting left empty is read from the environment instead, so
+// that noProxy also applies to a proxy given by HTTPS_PROXY.
+func ProxyFunc(httpsProxy string, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	proxyConfig := httpproxy.FromEnvironment()
+	if httpsProxy != "" {
+		if _, err := url.Parse(httpsProxy); err != nil {
+			return nil, fmt.Errorf("invalid https proxy '%s': %v", httpsProxy, err)
+		}
+		proxyConfig.HTTPProxy = httpsProxy
+		proxyConfig.HTTPSProxy = httpsProxy
+	}
+	if noProxy != "" {
+		proxyConfig.NoProxy = noProxy
+	}
+	proxy := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// newTransport builds the pooled transport shared by every request made through a client
+func newTransport(options clientOptions) *http.Transport {
+	tlsConfig := options.tlsConfig.Clone()
+	return &http.Transport{
+		Proxy: options.proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     options.http2,
+		MaxIdleConns:          options.maxIdleConns,
+		MaxIdleConnsPerHost:   options.maxIdleConnsPerHost,
+		IdleConnTimeout:       options.idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+}
@@ -2,36 +2,60 @@ package sysdighttp
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"syscall"
 	"time"
 )
 
 type SysdigClient interface {
 	SysdigRequest(logger *logrus.Logger, config SysdigRequestConfig) (*http.Response, error)
+	SysdigRequestWithContext(ctx context.Context, logger *logrus.Logger, config SysdigRequestConfig) (*http.Response, error)
 	ResponseBodyToJson(resp *http.Response, target interface{}) error
 }
 
-type sysdigClient struct{}
+// sysdigClient owns its transport so connections are pooled and reused across requests.  Certificates are verified
+// as configured through WithTLSConfig, which is the only way to disable verification.  The client is safe for
+// concurrent use, and the rate limiter is shared by every goroutine using it.
+type sysdigClient struct {
+	transport *http.Transport
+	headers   map[string]string
+	userAgent string
+	limiter   *tokenBucket
+}
 
-func NewSysdigClient() SysdigClient {
-	return &sysdigClient{}
+func NewSysdigClient(opts ...Option) SysdigClient {
+	options := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &sysdigClient{
+		transport: newTransport(options),
+		headers:   options.headers,
+		userAgent: options.userAgent,
+		limiter:   newTokenBucket(options.rateLimit, options.rateBurst),
+	}
 }
 
 type SysdigRequestConfig struct {
-	Method      string
-	Path        string
-	Headers     map[string]string
-	Params      map[string]interface{}
-	JSON        interface{}
-	Data        map[string]string
-	Auth        [2]string
+	Method  string
+	Path    string
+	Headers map[string]string
+	Params  map[string]interface{}
+	JSON    interface{}
+	Data    map[string]string
+	Auth    [2]string
+	// Verify must be true, requests cannot opt out of certificate verification.  Set tls.insecure_skip_verify, which
+	// is logged loudly, to disable it for a whole client.
 	Verify      bool
 	Stream      bool
 	MaxRetries  int
@@ -45,8 +69,8 @@ type SysdigRequestConfig struct {
 func DefaultSysdigRequestConfig(apiEndpoint string, secureToken string) SysdigRequestConfig {
 	return SysdigRequestConfig{
 		Method:      "GET",
-		Verify:      false,
-		MaxRetries:  1,
+		Verify:      true,
+		MaxRetries:  3,
 		BaseDelay:   5,
 		MaxDelay:    60,
 		Timeout:     600,
@@ -56,40 +80,174 @@ func DefaultSysdigRequestConfig(apiEndpoint string, secureToken string) SysdigRe
 }
 
 func (c *sysdigClient) SysdigRequest(logger *logrus.Logger, config SysdigRequestConfig) (*http.Response, error) {
-	retries := 0
+	return c.SysdigRequestWithContext(context.Background(), logger, config)
+}
+
+// SysdigRequestWithContext performs the request like SysdigRequest, aborting the request and any pending retry as
+// soon as the context is cancelled or its deadline expires.  Requests that are not idempotent, such as the POST
+// creating an alert, are only retried when the backend certainly did not apply them, see isRetryableError and
+// isRetryableStatus.
+func (c *sysdigClient) SysdigRequestWithContext(ctx context.Context, logger *logrus.Logger, config SysdigRequestConfig) (*http.Response, error) {
+	if !config.Verify {
+		return nil, errors.New("requests cannot disable certificate verification, use tls.insecure_skip_verify instead")
+	}
+
 	var resp *http.Response
 	var err error
+	var delay time.Duration
+
+	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			logger.Debugf("Retrying request to %s in %s (attempt %d of %d)", config.ApiEndpoint, delay, attempt, config.MaxRetries)
+			if err = sleepWithContext(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
 
-	for retries <= config.MaxRetries {
-		resp, err = makeRequest(&config)
+		if err = c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = c.makeRequest(ctx, &config)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			logger.Errorf("Error on HTTP request: %v", err)
-			time.Sleep(time.Duration(config.BaseDelay) * time.Second)
-			retries++
+			if !isRetryableError(config.Method, err) {
+				return nil, err
+			}
+			delay = backoffDelay(config, attempt)
 			continue
 		}
 
+		if isRetryableStatus(config.Method, resp.StatusCode) && attempt < config.MaxRetries {
+			wait, ok := retryAfterDelay(config, attempt, resp.Header.Get("Retry-After"))
+			if ok {
+				delay = wait
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				logger.Warnf("Received retryable HTTP status code: %d", resp.StatusCode)
+				continue
+			}
+			logger.Warnf("Not retrying HTTP status code %d, Retry-After %q exceeds the maximum delay of %ds", resp.StatusCode, resp.Header.Get("Retry-After"), config.MaxDelay)
+		}
+
 		if resp.StatusCode >= 400 {
 			respBody, _ := io.ReadAll(resp.Body)
 			_ = resp.Body.Close()
-			logger.Infof("Received HTTP status code: %d", resp.StatusCode)
-			logger.Infof("Response body: %s", string(respBody))
-			return resp, fmt.Errorf("HTTP request failed with status code: %d", resp.StatusCode)
+			// Leave the body readable for callers that want to inspect it themselves
+			resp.Body = io.NopCloser(bytes.NewReader(respBody))
+			logger.Debugf("Received HTTP status code: %d", resp.StatusCode)
+			logger.Debugf("Response body: %s", string(respBody))
+			return resp, newAPIError(resp.Request, resp, respBody)
 		}
 
 		logger.Debugf("Received HTTP status code: %d", resp.StatusCode)
 		return resp, nil
 	}
 
+	// Only failed requests exhaust the retries, retryable statuses are returned as an APIError on the last attempt.
+	// There is no response to return: the backend never answered.
 	logger.Errorf("Failed to fetch data from %s after %d retries.", config.ApiEndpoint, config.MaxRetries)
-	return &http.Response{
-		Status:     "503 Service Unavailable",
-		StatusCode: http.StatusServiceUnavailable,
-		Body:       io.NopCloser(bytes.NewBufferString("Service is unavailable after retries.")),
-	}, fmt.Errorf("service unavailable after %d retries", config.MaxRetries)
+	return nil, fmt.Errorf("request failed after %d retries: %w", config.MaxRetries, err)
+}
+
+// sleepWithContext waits for the delay, returning early with the context's error if it is cancelled first
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isIdempotent reports whether repeating a request with the given method has the same effect as sending it once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
 }
 
-func makeRequest(config *SysdigRequestConfig) (*http.Response, error) {
+// isRetryableError reports whether a request that failed with the given error can be sent again.  Only transient
+// network failures are retried: certificate, URL or encoding errors fail the same way on every attempt.  The backend
+// may have applied a request that failed after being sent, so requests that are not idempotent are only retried when
+// the connection could not even be established.
+func isRetryableError(method string, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if !isIdempotent(method) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isRetryableStatus reports whether a response status indicates a transient failure worth retrying.  A gateway
+// error may hide a request the backend applied, so requests that are not idempotent are only retried when rate
+// limited.
+func isRetryableStatus(method string, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(method)
+	}
+	return false
+}
+
+// backoffDelay returns the exponential delay before the next attempt, BaseDelay * 2^attempt capped at MaxDelay,
+// with "equal jitter" applied so that concurrent clients spread out their retries
+func backoffDelay(config SysdigRequestConfig, attempt int) time.Duration {
+	maxDelay := time.Duration(config.MaxDelay) * time.Second
+	delay := time.Duration(config.BaseDelay) * time.Second
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(delay-half)+1))
+}
+
+// retryAfterDelay honours a Retry-After header, given either in seconds or as an HTTP date, when it asks for a
+// longer wait than the backoff.  A request for a longer wait than MaxDelay is reported as not ok rather than
+// shortened, so that a rate-limited backend is not retried before it is ready.
+func retryAfterDelay(config SysdigRequestConfig, attempt int, retryAfter string) (time.Duration, bool) {
+	delay := backoffDelay(config, attempt)
+	if retryAfter == "" {
+		return delay, true
+	}
+
+	var requested time.Duration
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		requested = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		requested = time.Until(date)
+	}
+	if requested > time.Duration(config.MaxDelay)*time.Second {
+		return 0, false
+	}
+	if requested > delay {
+		delay = requested
+	}
+	return delay, true
+}
+
+func (c *sysdigClient) makeRequest(ctx context.Context, config *SysdigRequestConfig) (*http.Response, error) {
 	u, err := url.Parse(fmt.Sprintf("%s%s", config.ApiEndpoint, config.Path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %v", err)
@@ -116,21 +274,26 @@ func makeRequest(config *SysdigRequestConfig) (*http.Response, error) {
 		requestBody = bytes.NewBuffer(byteData)
 	}
 
-	req, err := http.NewRequest(config.Method, u.String(), requestBody)
+	req, err := http.NewRequestWithContext(ctx, config.Method, u.String(), requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
+	// Static client headers first so that per-request headers take precedence
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
 	for key, value := range config.Headers {
 		req.Header.Set(key, value)
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.SecureToken))
 
 	client := &http.Client{
-		Timeout: time.Duration(config.Timeout) * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: !config.Verify},
-		},
+		Timeout:   time.Duration(config.Timeout) * time.Second,
+		Transport: c.transport,
 	}
 	return client.Do(req)
 }
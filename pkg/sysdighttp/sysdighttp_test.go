@@ -0,0 +1,348 @@
+package sysdighttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/aaronm-sysdig/alerts-by-cluster/pkg/loggerpkg"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSuite(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Sysdighttp Suite")
+}
+
+var _ = ginkgo.Describe("Sysdighttp", func() {
+	ginkgo.It("should grow the backoff exponentially and cap it at MaxDelay", func() {
+		config := DefaultSysdigRequestConfig("", "")
+		config.BaseDelay = 2
+		config.MaxDelay = 10
+		for attempt, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+			delay := backoffDelay(config, attempt)
+			gomega.Expect(delay).Should(gomega.BeNumerically(">=", expected/2))
+			gomega.Expect(delay).Should(gomega.BeNumerically("<=", expected))
+		}
+	})
+
+	ginkgo.It("should honour Retry-After and give up when it exceeds MaxDelay", func() {
+		config := DefaultSysdigRequestConfig("", "")
+		config.BaseDelay = 1
+		config.MaxDelay = 30
+		delay, ok := retryAfterDelay(config, 0, "20")
+		gomega.Expect(ok).Should(gomega.BeTrue())
+		gomega.Expect(delay).Should(gomega.Equal(20 * time.Second))
+		_, ok = retryAfterDelay(config, 0, "120")
+		gomega.Expect(ok).Should(gomega.BeFalse())
+
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		config.ApiEndpoint = server.URL
+		resp, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+		gomega.Expect(resp.StatusCode).Should(gomega.Equal(http.StatusTooManyRequests))
+		gomega.Expect(requests).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should retry retryable status codes and return the eventual success", func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.BaseDelay = 0
+		resp, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		defer resp.Body.Close()
+		gomega.Expect(resp.StatusCode).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(requests).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("should only retry a POST when it was rate limited or never sent", func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.Method = http.MethodPost
+		config.BaseDelay = 0
+		_, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+		gomega.Expect(requests).Should(gomega.Equal(1))
+
+		gomega.Expect(isRetryableStatus(http.MethodPost, http.StatusTooManyRequests)).Should(gomega.BeTrue())
+		gomega.Expect(isRetryableStatus(http.MethodPut, http.StatusBadGateway)).Should(gomega.BeTrue())
+		gomega.Expect(isRetryableError(http.MethodPost, &net.OpError{Op: "dial", Err: errors.New("connection refused")})).Should(gomega.BeTrue())
+		gomega.Expect(isRetryableError(http.MethodPost, &net.OpError{Op: "read", Err: errors.New("connection reset")})).Should(gomega.BeFalse())
+		gomega.Expect(isRetryableError(http.MethodGet, io.ErrUnexpectedEOF)).Should(gomega.BeTrue())
+		gomega.Expect(isRetryableError(http.MethodGet, &net.OpError{Op: "read", Err: syscall.ECONNRESET})).Should(gomega.BeTrue())
+		gomega.Expect(isRetryableError(http.MethodGet, &url.Error{Op: "Get", Err: x509.UnknownAuthorityError{}})).Should(gomega.BeFalse())
+		gomega.Expect(isRetryableError(http.MethodGet, errors.New("failed to marshal JSON data"))).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return the last network error once retries are exhausted", func() {
+		// A port nothing listens on any more
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		address := listener.Addr().String()
+		gomega.Expect(listener.Close()).Should(gomega.Succeed())
+
+		config := DefaultSysdigRequestConfig("http://"+address, "token")
+		config.BaseDelay = 0
+		config.MaxRetries = 2
+		resp, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(resp).Should(gomega.BeNil())
+		gomega.Expect(err).Should(gomega.MatchError(gomega.HavePrefix("request failed after 2 retries: ")))
+		var opErr *net.OpError
+		gomega.Expect(errors.As(err, &opErr)).Should(gomega.BeTrue())
+		var apiErr *APIError
+		gomega.Expect(errors.As(err, &apiErr)).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should not retry client errors", func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusConflict)
+		}))
+		defer server.Close()
+
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.BaseDelay = 0
+		_, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+		gomega.Expect(requests).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should allow a burst and then pace requests at the configured rate", func() {
+		bucket := newTokenBucket(100, 2)
+		gomega.Expect(bucket.reserve()).Should(gomega.BeZero())
+		gomega.Expect(bucket.reserve()).Should(gomega.BeZero())
+		gomega.Expect(bucket.reserve()).Should(gomega.BeNumerically("~", 10*time.Millisecond, 2*time.Millisecond))
+		gomega.Expect(newTokenBucket(0, 10)).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should stop waiting for the rate limiter when the context is cancelled", func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+		}))
+		defer server.Close()
+
+		client := NewSysdigClient(WithRateLimit(0.01, 1))
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		_, err := client.SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = client.SysdigRequestWithContext(ctx, loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(requests).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should return an APIError with the decoded message and request ID", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "req-123")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"errors":[{"reason":"Forbidden","message":"missing permission scanning.alerts.edit"}]}`)
+		}))
+		defer server.Close()
+
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.Method = "POST"
+		config.Path = "/api/scanning/v1/alerts"
+		config.Params = map[string]interface{}{"filter": "secret"}
+		_, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+
+		var apiErr *APIError
+		gomega.Expect(errors.As(fmt.Errorf("wrapped: %w", err), &apiErr)).Should(gomega.BeTrue())
+		gomega.Expect(apiErr.Method).Should(gomega.Equal("POST"))
+		gomega.Expect(apiErr.URL).Should(gomega.Equal(server.URL + "/api/scanning/v1/alerts"))
+		gomega.Expect(apiErr.StatusCode).Should(gomega.Equal(http.StatusForbidden))
+		gomega.Expect(apiErr.Message).Should(gomega.Equal("Forbidden: missing permission scanning.alerts.edit"))
+		gomega.Expect(apiErr.RequestID).Should(gomega.Equal("req-123"))
+		gomega.Expect(err.Error()).Should(gomega.ContainSubstring("403 Forbidden: Forbidden: missing permission scanning.alerts.edit (request ID req-123)"))
+	})
+
+	ginkgo.It("should decode the error message formats used by the Sysdig APIs", func() {
+		gomega.Expect(errorMessage([]byte(`{"message":"Alert not found"}`))).Should(gomega.Equal("Alert not found"))
+		gomega.Expect(errorMessage([]byte(`{"error":"invalid token"}`))).Should(gomega.Equal("invalid token"))
+		gomega.Expect(errorMessage([]byte("<html>Bad Gateway</html>\n"))).Should(gomega.Equal("<html>Bad Gateway</html>"))
+		gomega.Expect(errorMessage(nil)).Should(gomega.Equal(""))
+	})
+
+	ginkgo.It("should abort pending retries when the context is cancelled", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.BaseDelay = 60
+		config.MaxDelay = 60
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		started := time.Now()
+		_, err := NewSysdigClient().SysdigRequestWithContext(ctx, loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(time.Since(started)).Should(gomega.BeNumerically("<", 5*time.Second))
+	})
+
+	ginkgo.It("should reuse pooled connections across requests", func() {
+		connections := 0
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections++
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		client := NewSysdigClient(WithMaxIdleConnsPerHost(2), WithIdleConnTimeout(time.Minute))
+		for i := 0; i < 3; i++ {
+			resp, err := client.SysdigRequest(loggerpkg.GetLogger(), DefaultSysdigRequestConfig(server.URL, "token"))
+			gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		gomega.Expect(connections).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should verify the backend certificate against the configured CA bundle", func() {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		handshakes := 0
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				handshakes++
+			}
+		}
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.StartTLS()
+		defer server.Close()
+		// Default retries: a certificate that fails verification fails the same way every time
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+
+		resp, err := NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(resp).Should(gomega.BeNil())
+		var certErr *tls.CertificateVerificationError
+		gomega.Expect(errors.As(err, &certErr)).Should(gomega.BeTrue(), fmt.Sprint(err))
+		gomega.Expect(handshakes).Should(gomega.Equal(1))
+
+		caBundle := filepath.Join(ginkgo.GinkgoT().TempDir(), "ca.pem")
+		certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		gomega.Expect(os.WriteFile(caBundle, certificate, 0600)).Should(gomega.Succeed())
+		tlsConfig, err := NewTLSConfig(TLSOptions{CABundle: caBundle, MinVersion: "1.2"})
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		resp, err = NewSysdigClient(WithTLSConfig(tlsConfig)).SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		_ = resp.Body.Close()
+
+		// Requests cannot opt out of verification on their own
+		config.Verify = false
+		_, err = NewSysdigClient().SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).Should(gomega.MatchError("requests cannot disable certificate verification, use tls.insecure_skip_verify instead"))
+		gomega.Expect(handshakes).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should reject incomplete client certificate configuration", func() {
+		_, err := NewTLSConfig(TLSOptions{ClientCert: "client.pem"})
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+		_, err = NewTLSConfig(TLSOptions{MinVersion: "2.0"})
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should merge static headers and the User-Agent into every request", func() {
+		var received http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewSysdigClient(
+			WithHeaders(map[string]string{"x-gateway-key": "static", "x-tenant": "static"}),
+			WithUserAgent("alerts-by-cluster/test"),
+		)
+		config := DefaultSysdigRequestConfig(server.URL, "token")
+		config.Headers = map[string]string{"X-Tenant": "request"}
+		resp, err := client.SysdigRequest(loggerpkg.GetLogger(), config)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		_ = resp.Body.Close()
+		gomega.Expect(received.Get("X-Gateway-Key")).Should(gomega.Equal("static"))
+		gomega.Expect(received.Get("X-Tenant")).Should(gomega.Equal("request"))
+		gomega.Expect(received.Get("User-Agent")).Should(gomega.Equal("alerts-by-cluster/test"))
+		gomega.Expect(received.Get("Authorization")).Should(gomega.Equal("Bearer token"))
+	})
+
+	ginkgo.It("should bypass the proxy for no_proxy hosts", func() {
+		proxy, err := ProxyFunc("http://proxy.internal:3128", "secure.internal")
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		req, _ := http.NewRequest("GET", "https://sysdig.example.com/api", nil)
+		proxyURL, err := proxy(req)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(proxyURL.Host).Should(gomega.Equal("proxy.internal:3128"))
+
+		req, _ = http.NewRequest("GET", "https://secure.internal/api", nil)
+		proxyURL, err = proxy(req)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(proxyURL).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should apply no_proxy to a proxy taken from the environment", func() {
+		ginkgo.GinkgoT().Setenv("HTTPS_PROXY", "http://env-proxy.internal:3128")
+		ginkgo.GinkgoT().Setenv("NO_PROXY", "")
+		proxy, err := ProxyFunc("", "secure.internal")
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+
+		req, _ := http.NewRequest("GET", "https://sysdig.example.com/api", nil)
+		proxyURL, err := proxy(req)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(proxyURL.Host).Should(gomega.Equal("env-proxy.internal:3128"))
+
+		req, _ = http.NewRequest("GET", "https://secure.internal/api", nil)
+		proxyURL, err = proxy(req)
+		gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+		gomega.Expect(proxyURL).Should(gomega.BeNil())
+	})
+})
@@ -0,0 +1,70 @@
+package sysdighttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions describes how the client verifies the backend and, optionally, authenticates itself with a client
+// certificate for mutual TLS
+type TLSOptions struct {
+	CABundle           string
+	ClientCert         string
+	ClientKey          string
+	MinVersion         string
+	InsecureSkipVerify bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig builds a tls.Config from the options.  The CA bundle is added to the system roots, and the client
+// certificate and key must be given together.
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version '%s', expected one of 1.0, 1.1, 1.2, 1.3", options.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if options.CABundle != "" {
+		pem, err := os.ReadFile(options.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle '%s'", options.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (options.ClientCert == "") != (options.ClientKey == "") {
+		return nil, fmt.Errorf("client certificate and client key must be configured together")
+	}
+	if options.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -5,6 +5,31 @@ type AlertQuery struct {
 }
 
 type Alert struct {
-	Name  string `json:"name"`
-	Scope string `json:"scope"`
+	AlertID                string          `json:"alertId"`
+	Enabled                bool            `json:"enabled"`
+	Type                   string          `json:"type"`
+	Name                   string          `json:"name"`
+	Description            string          `json:"description"`
+	Scope                  string          `json:"scope"`
+	Repositories           []string        `json:"repositories"`
+	Triggers               PayloadTriggers `json:"triggers"`
+	Autoscan               bool            `json:"autoscan"`
+	OnlyPassFail           bool            `json:"onlyPassFail"`
+	NotificationChannelIds []string        `json:"notificationChannelIds"`
+}
+
+// Payload returns the writable fields of an existing alert in the shape used for create and update requests
+func (a Alert) Payload() PayloadAlert {
+	return PayloadAlert{
+		Enabled:                a.Enabled,
+		Type:                   a.Type,
+		Name:                   a.Name,
+		Description:            a.Description,
+		Scope:                  a.Scope,
+		Repositories:           a.Repositories,
+		Triggers:               a.Triggers,
+		Autoscan:               a.Autoscan,
+		OnlyPassFail:           a.OnlyPassFail,
+		NotificationChannelIds: a.NotificationChannelIds,
+	}
 }
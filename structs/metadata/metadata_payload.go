@@ -1,8 +1,16 @@
 package metadata
 
 type PayloadMetadata struct {
-	Paging  PagingPayload `json:"paging"`
-	Metrics []string      `json:"metrics"`
+	Time    *TimeRangePayload `json:"time,omitempty"`
+	Filter  string            `json:"filter,omitempty"`
+	Paging  PagingPayload     `json:"paging"`
+	Metrics []string          `json:"metrics"`
+}
+
+// TimeRangePayload bounds the query, in microseconds since the epoch
+type TimeRangePayload struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
 }
 
 type PagingPayload struct {
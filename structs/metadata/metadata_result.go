@@ -13,8 +13,21 @@ type TimeRangeMetadataResult struct {
 	Sampling int64 `json:"sampling"`
 }
 
-type DataMetadataResult struct {
-	KubernetesClusterName string `json:"kubernetes.cluster.name"`
+// DataMetadataResult is a row of label values, keyed by the metrics requested in PayloadMetadata
+type DataMetadataResult map[string]string
+
+// KubernetesClusterName returns the kubernetes.cluster.name label of the row
+func (d DataMetadataResult) KubernetesClusterName() string {
+	return d["kubernetes.cluster.name"]
+}
+
+// Values returns the values of the given labels, an empty string for each label the row lacks
+func (d DataMetadataResult) Values(labels []string) []string {
+	values := make([]string, 0, len(labels))
+	for _, label := range labels {
+		values = append(values, d[label])
+	}
+	return values
 }
 
 type PagingMetadataResult struct {
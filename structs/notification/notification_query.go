@@ -0,0 +1,12 @@
+package notification
+
+type ChannelQuery struct {
+	NotificationChannels []Channel `json:"notificationChannels"`
+}
+
+type Channel struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}